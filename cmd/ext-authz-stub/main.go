@@ -0,0 +1,29 @@
+// Command ext-authz-stub is a minimal HTTP authorization service, to try the external authorization of the Envoy
+// control plane locally, with -envoy-ext-authz=http -envoy-ext-authz-address=localhost:9001.
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"k8s.io/klog"
+)
+
+var address = flag.String("address", ":9001", "Address the authorization service listens on")
+var token = flag.String("token", "", "Bearer token the requests must have to be allowed. Empty allows all the requests")
+
+func main() {
+	flag.Parse()
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if *token != "" && r.Header.Get("Authorization") != "Bearer "+*token {
+			klog.Infof("Denied %s %s%s", r.Method, r.Host, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		klog.Infof("Allowed %s %s%s", r.Method, r.Host, r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	klog.Fatal(http.ListenAndServe(*address, nil))
+}
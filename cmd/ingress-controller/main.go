@@ -2,11 +2,14 @@ package main
 
 import (
 	"flag"
+	"net/http"
+	"os"
+	"time"
 
+	"github.com/jmprusi/kcp-ingress/pkg/envoy"
 	"github.com/jmprusi/kcp-ingress/pkg/reconciler/ingress"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
-	envoyserver "knative.dev/net-kourier/pkg/envoy/server"
 )
 
 const numThreads = 2
@@ -22,6 +25,56 @@ var envoyXDSPort = flag.Uint("envoyxds-port", 18000, "Envoy control plane port")
 var domain = flag.String("domain", "kcp-apps.127.0.0.1.nip.io", "The domain to use to expose ingresses")
 
 var envoyListenPort = flag.Uint("envoy-listener-port", 80, "Envoy default listener port")
+var envoyTLSListenPort = flag.Uint("envoy-tls-listener-port", 443, "Envoy TLS listener port")
+var envoyUpstreamPort = flag.Uint("envoy-upstream-port", 80, "HTTP port of the cluster gateways, used when their status doesn't list their ports")
+var envoyUpstreamTLSPort = flag.Uint("envoy-upstream-tls-port", 443, "HTTPS port of the cluster gateways, TLS is originated to the gateways only exposing it")
+var envoyUpstreamCA = flag.String("envoy-upstream-ca", "", "PEM bundle of the CAs signing the certificates of the cluster gateways serving HTTPS. Defaults to the CAs of the Envoy image")
+var envoyUpstreamInsecureSkipVerify = flag.Bool("envoy-upstream-insecure-skip-verify", false, "Don't verify the certificates of the cluster gateways serving HTTPS")
+var envoyIPFamily = flag.String("envoy-ip-family", envoy.IPFamilyIPv4, "IP family Envoy listens on and reaches the cluster gateways with: ipv4, ipv6 or dual")
+var envoyFleets = flag.String("envoy-fleets", envoy.NodeID,
+	"Comma separated Envoy fleets, each one getting its own configuration, optionally with their region and zone like edge-eu=eu-west-1/eu-west-1a")
+var envoyFleetMetadataKey = flag.String("envoy-fleet-metadata-key", "", "Node metadata key whose value is the fleet of an Envoy, like region. Envoys without it, or all of them if empty, are mapped by node ID")
+var envoyRegion = flag.String("envoy-region", "", "Region of the fleets without one, clusters in the same region are preferred when failing over")
+var envoyZone = flag.String("envoy-zone", "", "Zone of the fleets without region, clusters in the same zone are preferred when failing over")
+var envoyImplementationSpecificPathType = flag.String("envoy-implementation-specific-path-type", envoy.PathTypePrefix,
+	"How Envoy matches paths with the ImplementationSpecific type, either as a prefix (Prefix) or a regular expression (Regex)")
+
+var envoyHealthCheckProtocol = flag.String("envoy-health-check-protocol", envoy.HealthCheckTCP, "Protocol of the active health checks of the cluster gateways: http, tcp or none")
+var envoyHealthCheckPath = flag.String("envoy-health-check-path", "/", "Path requested by HTTP health checks")
+var envoyHealthCheckHost = flag.String("envoy-health-check-host", "", "Host requested by the HTTP health checks of the Ingresses without a rule host that isn't a wildcard")
+var envoyHealthCheckInterval = flag.Duration("envoy-health-check-interval", 10*time.Second, "Interval between health checks")
+var envoyHealthCheckTimeout = flag.Duration("envoy-health-check-timeout", 2*time.Second, "Timeout of each health check")
+var envoyHealthCheckHealthyThreshold = flag.Uint("envoy-health-check-healthy-threshold", 2, "Consecutive successful health checks to mark a gateway healthy")
+var envoyHealthCheckUnhealthyThreshold = flag.Uint("envoy-health-check-unhealthy-threshold", 3, "Consecutive failed health checks to mark a gateway unhealthy")
+var envoyHealthCheckExpectedStatuses = flag.String("envoy-health-check-expected-statuses", "", "HTTP statuses considered healthy, like 200-399,404. Defaults to 200")
+var envoyOutlierConsecutive5xx = flag.Uint("envoy-outlier-consecutive-5xx", 5, "Consecutive 5xx responses to eject a cluster gateway, 0 disables outlier detection")
+var envoyOutlierInterval = flag.Duration("envoy-outlier-interval", 10*time.Second, "Interval between outlier detection sweeps")
+var envoyOutlierBaseEjectionTime = flag.Duration("envoy-outlier-base-ejection-time", 30*time.Second, "Base duration a cluster gateway is ejected for")
+var envoyOutlierMaxEjectionPercent = flag.Uint("envoy-outlier-max-ejection-percent", 50, "Maximum percentage of the cluster gateways that can be ejected")
+var envoyMaxConnections = flag.Uint("envoy-max-connections", 1024, "Maximum connections of each Envoy cluster, to all its cluster gateways")
+var envoyMaxPendingRequests = flag.Uint("envoy-max-pending-requests", 1024, "Maximum pending requests of each Envoy cluster, to all its cluster gateways")
+var envoyMaxRequests = flag.Uint("envoy-max-requests", 1024, "Maximum parallel requests of each Envoy cluster, to all its cluster gateways")
+var envoyRequestTimeout = flag.Duration("envoy-request-timeout", 0, "Timeout of the requests to the cluster gateways, including retries. 0 disables it")
+var envoyIdleTimeout = flag.Duration("envoy-idle-timeout", 0, "Timeout of the requests without activity. 0 keeps the Envoy default")
+var envoyConnectTimeout = flag.Duration("envoy-connect-timeout", 2*time.Second, "Timeout of the connections to the cluster gateways")
+var envoyRetryOn = flag.String("envoy-retry-on", "", "Comma separated Envoy retry conditions, like 5xx,reset. Empty disables retries")
+var envoyRetriableStatusCodes = flag.String("envoy-retriable-status-codes", "", "Comma separated statuses retried with the retriable-status-codes retry condition, like 409,503")
+var envoyRetriableHeaders = flag.String("envoy-retriable-headers", "", "Comma separated response headers retried with the retriable-headers retry condition")
+var envoyNumRetries = flag.Uint("envoy-num-retries", 1, "Maximum number of retries of a request")
+var envoyPerTryTimeout = flag.Duration("envoy-per-try-timeout", 0, "Timeout of each try of a request. 0 uses the request timeout")
+var envoyAccessLog = flag.String("envoy-access-log", envoy.AccessLogNone, "Where Envoy writes the access logs: none, stdout, file or grpc")
+var envoyAccessLogPath = flag.String("envoy-access-log-path", "", "File the access logs are written to, with -envoy-access-log=file")
+var envoyAccessLogFormat = flag.String("envoy-access-log-format", envoy.DefaultAccessLogFormat, "Format of the access logs written to stdout or a file. %UPSTREAM_CLUSTER% only names the leaf cluster when the traffic is split, %UPSTREAM_HOST% is the address of the leaf gateway")
+var envoyAccessLogCollector = flag.String("envoy-access-log-collector", "", "host:port of the gRPC access log service, with -envoy-access-log=grpc")
+var envoyRateLimitStatus = flag.Uint("envoy-rate-limit-status", 429, "Status of the requests over the rate limit of their Ingress")
+var envoyExtAuthz = flag.String("envoy-ext-authz", envoy.ExtAuthzNone, "Protocol of the external authorization service: none, grpc or http")
+var envoyExtAuthzAddress = flag.String("envoy-ext-authz-address", "", "host:port of the external authorization service")
+var envoyExtAuthzPathPrefix = flag.String("envoy-ext-authz-path-prefix", "", "Prefix of the paths of the requests sent to an HTTP authorization service")
+var envoyExtAuthzTimeout = flag.Duration("envoy-ext-authz-timeout", 200*time.Millisecond, "Timeout of the authorization checks")
+var envoyExtAuthzFailureMode = flag.String("envoy-ext-authz-failure-mode", envoy.ExtAuthzFailureModeDeny, "Whether requests are denied or allowed when the authorization service fails: deny or allow")
+
+var debugAddress = flag.String("debug-address", "", "Address to serve the debug endpoints on, like :8080. Disabled if empty")
+var envoyAdminAddress = flag.String("envoy-admin-address", "unix:///tmp/envoy.admin", "Envoy admin API address, either an URL or a unix socket")
 
 func main() {
 	flag.Parse()
@@ -44,8 +97,124 @@ func main() {
 	}
 
 	if *envoyEnableXDS {
-		controllerConfig.EnvoyXDS = envoyserver.NewXdsServer(*envoyXDSPort, nil)
-		controllerConfig.EnvoyListenPort = envoyListenPort
+		fleets, err := envoy.ParseFleets(*envoyFleets, *envoyRegion, *envoyZone)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		if *envoyImplementationSpecificPathType != envoy.PathTypePrefix && *envoyImplementationSpecificPathType != envoy.PathTypeRegex {
+			klog.Fatalf("Invalid ImplementationSpecific path type %q", *envoyImplementationSpecificPathType)
+		}
+		if *envoyRateLimitStatus < 400 || *envoyRateLimitStatus > 599 {
+			klog.Fatalf("Invalid rate limit status %d", *envoyRateLimitStatus)
+		}
+		if *envoyIPFamily != envoy.IPFamilyIPv4 && *envoyIPFamily != envoy.IPFamilyIPv6 && *envoyIPFamily != envoy.IPFamilyDualStack {
+			klog.Fatalf("Invalid IP family %q", *envoyIPFamily)
+		}
+		healthCheck := &envoy.HealthCheckConfig{
+			Protocol:           *envoyHealthCheckProtocol,
+			Path:               *envoyHealthCheckPath,
+			Host:               *envoyHealthCheckHost,
+			Interval:           *envoyHealthCheckInterval,
+			Timeout:            *envoyHealthCheckTimeout,
+			HealthyThreshold:   uint32(*envoyHealthCheckHealthyThreshold),
+			UnhealthyThreshold: uint32(*envoyHealthCheckUnhealthyThreshold),
+			ExpectedStatuses:   *envoyHealthCheckExpectedStatuses,
+		}
+		if err := healthCheck.Validate(); err != nil {
+			klog.Fatal(err)
+		}
+		outlierDetection := &envoy.OutlierDetectionConfig{
+			Consecutive5xx:     uint32(*envoyOutlierConsecutive5xx),
+			Interval:           *envoyOutlierInterval,
+			BaseEjectionTime:   *envoyOutlierBaseEjectionTime,
+			MaxEjectionPercent: uint32(*envoyOutlierMaxEjectionPercent),
+		}
+		if err := outlierDetection.Validate(); err != nil {
+			klog.Fatal(err)
+		}
+		circuitBreakers := &envoy.CircuitBreakersConfig{
+			MaxConnections:     uint32(*envoyMaxConnections),
+			MaxPendingRequests: uint32(*envoyMaxPendingRequests),
+			MaxRequests:        uint32(*envoyMaxRequests),
+		}
+		if err := circuitBreakers.Validate(); err != nil {
+			klog.Fatal(err)
+		}
+		timeouts := &envoy.TimeoutConfig{
+			Request: *envoyRequestTimeout,
+			Idle:    *envoyIdleTimeout,
+			Connect: *envoyConnectTimeout,
+		}
+		if err := timeouts.Validate(); err != nil {
+			klog.Fatal(err)
+		}
+		retries := &envoy.RetryConfig{
+			RetryOn:              *envoyRetryOn,
+			NumRetries:           uint32(*envoyNumRetries),
+			PerTryTimeout:        *envoyPerTryTimeout,
+			RetriableStatusCodes: *envoyRetriableStatusCodes,
+			RetriableHeaders:     *envoyRetriableHeaders,
+		}
+		if err := retries.Validate(); err != nil {
+			klog.Fatal(err)
+		}
+		accessLog := &envoy.AccessLogConfig{
+			Sink:             *envoyAccessLog,
+			Path:             *envoyAccessLogPath,
+			Format:           *envoyAccessLogFormat,
+			CollectorAddress: *envoyAccessLogCollector,
+		}
+		if err := accessLog.Validate(); err != nil {
+			klog.Fatal(err)
+		}
+		extAuthz := &envoy.ExtAuthzConfig{
+			Protocol:    *envoyExtAuthz,
+			Address:     *envoyExtAuthzAddress,
+			PathPrefix:  *envoyExtAuthzPathPrefix,
+			Timeout:     *envoyExtAuthzTimeout,
+			FailureMode: *envoyExtAuthzFailureMode,
+		}
+		if err := extAuthz.Validate(); err != nil {
+			klog.Fatal(err)
+		}
+
+		upstreamTLS := &envoy.UpstreamTLSConfig{InsecureSkipVerify: *envoyUpstreamInsecureSkipVerify}
+		if *envoyUpstreamCA != "" {
+			if upstreamTLS.CA, err = os.ReadFile(*envoyUpstreamCA); err != nil {
+				klog.Fatal(err)
+			}
+		}
+		if err := upstreamTLS.Validate(); err != nil {
+			klog.Fatal(err)
+		}
+
+		controllerConfig.EnvoyXDS = envoy.NewXdsServer(*envoyXDSPort, envoy.FleetHash{MetadataKey: *envoyFleetMetadataKey})
+		controllerConfig.EnvoyFleets = fleets
+		controllerConfig.EnvoyTranslator = &envoy.TranslatorConfig{
+			EnvoyListenPort:                envoyListenPort,
+			EnvoyTLSListenPort:             envoyTLSListenPort,
+			UpstreamPort:                   envoyUpstreamPort,
+			UpstreamTLSPort:                envoyUpstreamTLSPort,
+			UpstreamTLS:                    upstreamTLS,
+			IPFamily:                       envoyIPFamily,
+			ImplementationSpecificPathType: envoyImplementationSpecificPathType,
+			HealthCheck:                    healthCheck,
+			OutlierDetection:               outlierDetection,
+			CircuitBreakers:                circuitBreakers,
+			Timeouts:                       timeouts,
+			Retries:                        retries,
+			AccessLog:                      accessLog,
+			RateLimitStatus:                envoyRateLimitStatus,
+			ExtAuthz:                       extAuthz,
+		}
+
+		if *debugAddress != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/debug/envoy/health", envoy.NewHealthHandler(*envoyAdminAddress))
+			go func() {
+				klog.Fatal(http.ListenAndServe(*debugAddress, mux))
+			}()
+		}
 	}
 
 	ingress.NewController(controllerConfig).Start(numThreads)
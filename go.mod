@@ -9,7 +9,6 @@ require (
 	github.com/go-logr/logr v1.1.0 // indirect
 	github.com/go-openapi/spec v0.19.6 // indirect
 	github.com/go-openapi/swag v0.19.15 // indirect
-	github.com/google/uuid v1.3.0
 	github.com/imdario/mergo v0.3.9 // indirect
 	github.com/influxdata/tdigest v0.0.0-20181121200506-bf2b5ad3c0a9 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
@@ -18,12 +17,14 @@ require (
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	golang.org/x/text v0.3.7 // indirect
+	google.golang.org/grpc v1.42.0
 	google.golang.org/protobuf v1.27.1
 	k8s.io/api v0.22.2
 	k8s.io/apimachinery v0.22.2
 	k8s.io/client-go v0.21.4
 	k8s.io/klog v1.0.0
 	k8s.io/klog/v2 v2.20.0 // indirect
+	k8s.io/utils v0.0.0-20210707171843-4b05e18ac7d9
 	knative.dev/net-kourier v0.28.0
 )
 
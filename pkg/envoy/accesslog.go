@@ -0,0 +1,267 @@
+package envoy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+
+	envoyaccesslogv3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyendpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoyfilelogv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
+	envoygrpclogv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/grpc/v3"
+	envoystreamlogv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/stream/v3"
+	envoyluav3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/lua/v3"
+	envoyfilterhcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoymatcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	envoytypev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+const (
+	// AccessLogNone disables access logging.
+	AccessLogNone = "none"
+	// AccessLogStdout writes the access logs to the standard output of Envoy.
+	AccessLogStdout = "stdout"
+	// AccessLogFile writes the access logs to a file.
+	AccessLogFile = "file"
+	// AccessLogGRPC sends the access logs to a gRPC access log service.
+	AccessLogGRPC = "grpc"
+
+	// DefaultAccessLogFormat logs the Envoy cluster of the request, which is the Ingress key, suffixed by the leaf
+	// cluster when the traffic is split, and the cluster gateway that got it. Envoy can't log the locality of the
+	// gateway, so without traffic split the leaf is only identified by the address of its gateway.
+	DefaultAccessLogFormat = `[%START_TIME%] "%REQ(:METHOD)% %REQ(X-ENVOY-ORIGINAL-PATH?:PATH)% %PROTOCOL%" ` +
+		`%RESPONSE_CODE% %RESPONSE_FLAGS% %BYTES_RECEIVED% %BYTES_SENT% %DURATION% "%REQ(:AUTHORITY)%" ` +
+		`ingress=%UPSTREAM_CLUSTER% upstream=%UPSTREAM_HOST%` + "\n"
+
+	stdoutAccessLog = "envoy.access_loggers.stdout"
+	// accessLogCollectorCluster is the Envoy cluster of the gRPC access log service.
+	accessLogCollectorCluster = "access_log_collector"
+	// The routes of the Ingresses setting the AccessLogSamplingAnnotation have their sampling in their metadata. The
+	// access log filters only see the dynamic metadata of the requests, where the Lua filter copies it.
+	accessLogSamplingNamespace = "kcp.ingress.access_log"
+	accessLogSamplingKey       = "sampling"
+	accessLogSamplingCode      = `function envoy_on_request(request_handle)
+  local sampling = request_handle:metadata():get("` + accessLogSamplingKey + `")
+  if sampling ~= nil then
+    request_handle:streamInfo():dynamicMetadata():set("` + accessLogSamplingNamespace + `", "` + accessLogSamplingKey + `", sampling)
+  end
+end
+`
+)
+
+// AccessLogConfig configures the access logs of the requests served by Envoy.
+type AccessLogConfig struct {
+	// Sink is one of AccessLogNone, AccessLogStdout, AccessLogFile or AccessLogGRPC.
+	Sink string
+	// Path is the file the access logs are written to, with AccessLogFile.
+	Path string
+	// Format of the access logs written to the standard output or a file, with the Envoy command operators.
+	Format string
+	// CollectorAddress is the host:port of the gRPC access log service, with AccessLogGRPC.
+	CollectorAddress string
+}
+
+// Validate returns an error if the configuration can't be translated.
+func (c AccessLogConfig) Validate() error {
+	switch c.Sink {
+	case AccessLogNone, AccessLogStdout:
+		return nil
+	case AccessLogFile:
+		if c.Path == "" {
+			return fmt.Errorf("the access log file path is required")
+		}
+		return nil
+	case AccessLogGRPC:
+		if _, port, err := net.SplitHostPort(c.CollectorAddress); err != nil {
+			return fmt.Errorf("invalid access log collector address: %v", err)
+		} else if _, err := strconv.ParseUint(port, 10, 32); err != nil {
+			return fmt.Errorf("invalid access log collector port: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown access log sink %q", c.Sink)
+}
+
+// accessLogSampling returns the percentage of the requests of the Ingress that are logged, from its
+// AccessLogSamplingAnnotation, or nil to log them all.
+func accessLogSampling(ingress networkingv1.Ingress) (*uint32, error) {
+	if _, ok := ingress.Annotations[AccessLogSamplingAnnotation]; !ok {
+		return nil, nil
+	}
+	var sampling uint32
+	if err := parseUint32Annotation(ingress.Annotations, AccessLogSamplingAnnotation, &sampling); err != nil {
+		return nil, err
+	}
+	if sampling > 100 {
+		return nil, fmt.Errorf("invalid %s: must be between 0 and 100", AccessLogSamplingAnnotation)
+	}
+	return &sampling, nil
+}
+
+// setAccessLogSampling tags the route with the sampling of its Ingress, in the metadata read by the Lua filter.
+func setAccessLogSampling(route *envoyroutev3.Route, sampling uint32) {
+	if route.Metadata == nil {
+		route.Metadata = &envoycorev3.Metadata{FilterMetadata: map[string]*structpb.Struct{}}
+	}
+	route.Metadata.FilterMetadata[wellknown.Lua] = &structpb.Struct{Fields: map[string]*structpb.Value{
+		accessLogSamplingKey: structpb.NewStringValue(strconv.Itoa(int(sampling))),
+	}}
+}
+
+// newAccessLogSamplingFilter returns the Lua filter copying the sampling of the routes to the dynamic metadata of
+// their requests, or nil when no request is sampled.
+func (t *translator) newAccessLogSamplingFilter(samplings []uint32) (*envoyfilterhcmv3.HttpFilter, error) {
+	if t.accessLog.Sink == AccessLogNone || len(samplings) == 0 {
+		return nil, nil
+	}
+	configAny, err := marshalAny(&envoyluav3.Lua{InlineCode: accessLogSamplingCode})
+	if err != nil {
+		return nil, err
+	}
+	return &envoyfilterhcmv3.HttpFilter{
+		Name:       wellknown.Lua,
+		ConfigType: &envoyfilterhcmv3.HttpFilter_TypedConfig{TypedConfig: configAny},
+	}, nil
+}
+
+// newAccessLogs returns the access logs of the HTTP connection managers, sampling the requests of the Ingresses with
+// the given samplings.
+func (t *translator) newAccessLogs(samplings []uint32) []*envoyaccesslogv3.AccessLog {
+	var config proto.Message
+	name := ""
+	format := &envoycorev3.SubstitutionFormatString{
+		Format: &envoycorev3.SubstitutionFormatString_TextFormatSource{
+			TextFormatSource: &envoycorev3.DataSource{
+				Specifier: &envoycorev3.DataSource_InlineString{InlineString: t.accessLog.Format},
+			},
+		},
+	}
+
+	switch t.accessLog.Sink {
+	case AccessLogStdout:
+		name = stdoutAccessLog
+		config = &envoystreamlogv3.StdoutAccessLog{
+			AccessLogFormat: &envoystreamlogv3.StdoutAccessLog_LogFormat{LogFormat: format},
+		}
+	case AccessLogFile:
+		name = wellknown.FileAccessLog
+		config = &envoyfilelogv3.FileAccessLog{
+			Path:            t.accessLog.Path,
+			AccessLogFormat: &envoyfilelogv3.FileAccessLog_LogFormat{LogFormat: format},
+		}
+	case AccessLogGRPC:
+		name = wellknown.HTTPGRPCAccessLog
+		config = &envoygrpclogv3.HttpGrpcAccessLogConfig{
+			CommonConfig: &envoygrpclogv3.CommonGrpcAccessLogConfig{
+				LogName: NodeID,
+				GrpcService: &envoycorev3.GrpcService{
+					TargetSpecifier: &envoycorev3.GrpcService_EnvoyGrpc_{
+						EnvoyGrpc: &envoycorev3.GrpcService_EnvoyGrpc{ClusterName: accessLogCollectorCluster},
+					},
+				},
+				TransportApiVersion: envoycorev3.ApiVersion_V3,
+			},
+		}
+	default:
+		return nil
+	}
+
+	configAny, err := marshalAny(config)
+	if err != nil {
+		log.Printf("failed to configure access logs: %v", err)
+		return nil
+	}
+	return []*envoyaccesslogv3.AccessLog{{
+		Name:       name,
+		Filter:     newAccessLogSamplingLogFilter(samplings),
+		ConfigType: &envoyaccesslogv3.AccessLog_TypedConfig{TypedConfig: configAny},
+	}}
+}
+
+// newAccessLogSamplingLogFilter returns a filter logging all the requests without a sampling, and the given percentage
+// of the requests with each sampling.
+func newAccessLogSamplingLogFilter(samplings []uint32) *envoyaccesslogv3.AccessLogFilter {
+	if len(samplings) == 0 {
+		return nil
+	}
+
+	// No sampling is ever empty, so only the requests without one are matched.
+	filters := []*envoyaccesslogv3.AccessLogFilter{newAccessLogMetadataFilter("", true)}
+	for _, sampling := range samplings {
+		value := strconv.Itoa(int(sampling))
+		filters = append(filters, &envoyaccesslogv3.AccessLogFilter{
+			FilterSpecifier: &envoyaccesslogv3.AccessLogFilter_AndFilter{AndFilter: &envoyaccesslogv3.AndFilter{
+				Filters: []*envoyaccesslogv3.AccessLogFilter{
+					newAccessLogMetadataFilter(value, false),
+					{FilterSpecifier: &envoyaccesslogv3.AccessLogFilter_RuntimeFilter{RuntimeFilter: &envoyaccesslogv3.RuntimeFilter{
+						RuntimeKey:     "access_log.sampling_" + value,
+						PercentSampled: &envoytypev3.FractionalPercent{Numerator: sampling, Denominator: envoytypev3.FractionalPercent_HUNDRED},
+					}}},
+				},
+			}},
+		})
+	}
+
+	return &envoyaccesslogv3.AccessLogFilter{
+		FilterSpecifier: &envoyaccesslogv3.AccessLogFilter_OrFilter{OrFilter: &envoyaccesslogv3.OrFilter{Filters: filters}},
+	}
+}
+
+// newAccessLogMetadataFilter returns a filter matching the requests with the given sampling, and the requests without
+// one if ifNotFound is set.
+func newAccessLogMetadataFilter(sampling string, ifNotFound bool) *envoyaccesslogv3.AccessLogFilter {
+	return &envoyaccesslogv3.AccessLogFilter{
+		FilterSpecifier: &envoyaccesslogv3.AccessLogFilter_MetadataFilter{MetadataFilter: &envoyaccesslogv3.MetadataFilter{
+			Matcher: &envoymatcherv3.MetadataMatcher{
+				Filter: accessLogSamplingNamespace,
+				Path: []*envoymatcherv3.MetadataMatcher_PathSegment{{
+					Segment: &envoymatcherv3.MetadataMatcher_PathSegment_Key{Key: accessLogSamplingKey},
+				}},
+				Value: &envoymatcherv3.ValueMatcher{MatchPattern: &envoymatcherv3.ValueMatcher_StringMatch{
+					StringMatch: &envoymatcherv3.StringMatcher{MatchPattern: &envoymatcherv3.StringMatcher_Exact{Exact: sampling}},
+				}},
+			},
+			MatchIfKeyNotFound: wrapperspb.Bool(ifNotFound),
+		}},
+	}
+}
+
+// newAccessLogCollectorCluster returns the cluster of the gRPC access log service, or nil when the access logs aren't
+// sent to one.
+func (t *translator) newAccessLogCollectorCluster() *envoyclusterv3.Cluster {
+	if t.accessLog.Sink != AccessLogGRPC {
+		return nil
+	}
+	host, port, _ := net.SplitHostPort(t.accessLog.CollectorAddress)
+	portNumber, _ := strconv.ParseUint(port, 10, 32)
+
+	cluster := t.newCluster(accessLogCollectorCluster, t.timeouts.Connect, []*envoyendpointv3.LocalityLbEndpoints{{
+		LbEndpoints: []*envoyendpointv3.LbEndpoint{t.newLBEndpoint(host, uint32(portNumber), false)},
+	}}, envoyclusterv3.Cluster_STRICT_DNS)
+	if err := t.setUpstreamProtocol(cluster, BackendProtocolGRPC, ""); err != nil {
+		log.Printf("failed to configure access log collector cluster: %v", err)
+		return nil
+	}
+	return cluster
+}
+
+// sortedSamplings returns the distinct samplings of the Ingresses, sorted so the access logs don't change with the
+// order of the Ingresses.
+func sortedSamplings(samplings map[uint32]struct{}) []uint32 {
+	sorted := make([]uint32, 0, len(samplings))
+	for sampling := range samplings {
+		sorted = append(sorted, sampling)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
@@ -0,0 +1,40 @@
+package envoy
+
+import (
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+)
+
+func TestAccessLogSampling(t *testing.T) {
+	tr := newTestTranslator()
+	tr.accessLog = AccessLogConfig{Sink: AccessLogStdout, Format: DefaultAccessLogFormat}
+
+	ingress := newTestIngress("foo", "foo.com")
+	ingress.Annotations[AccessLogSamplingAnnotation] = "10"
+	_, _, routes, _ := tr.translateIngress(ingress, testLeaves, nil)
+
+	for _, r := range routes {
+		if len(r.route.RequestHeadersToAdd) > 0 {
+			t.Errorf("expected the sampling not to be sent in request headers, got %v", r.route.RequestHeadersToAdd)
+		}
+		sampling := r.route.GetMetadata().GetFilterMetadata()[wellknown.Lua].GetFields()[accessLogSamplingKey].GetStringValue()
+		if sampling != "10" {
+			t.Errorf("expected the route to be tagged with the sampling, got %q", sampling)
+		}
+		if err := r.route.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	hcm := tr.newHTTPConnectionManager("ingress_http", "defaultroute", []uint32{10})
+	if err := hcm.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if hcm.HttpFilters[0].Name != wellknown.Lua {
+		t.Errorf("expected the sampling filter to be first, got %q", hcm.HttpFilters[0].Name)
+	}
+	if hcm.AccessLog[0].GetFilter().GetOrFilter() == nil {
+		t.Errorf("expected the access logs to be sampled")
+	}
+}
@@ -0,0 +1,112 @@
+package envoy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	envoyadminv3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// ClusterHealth is the health of the endpoints of an Envoy cluster, as seen by Envoy.
+type ClusterHealth struct {
+	Name  string       `json:"name"`
+	Hosts []HostHealth `json:"hosts"`
+}
+
+type HostHealth struct {
+	Address                  string `json:"address"`
+	Healthy                  bool   `json:"healthy"`
+	FailedActiveHealthCheck  bool   `json:"failedActiveHealthCheck,omitempty"`
+	FailedOutlierCheck       bool   `json:"failedOutlierCheck,omitempty"`
+	PendingActiveHealthCheck bool   `json:"pendingActiveHealthCheck,omitempty"`
+}
+
+// FetchClusterHealth queries the Envoy admin API for the health of the endpoints of all its clusters.
+// The admin address is either an URL, like "http://127.0.0.1:9901", or a unix socket, like "unix:///tmp/envoy.admin".
+func FetchClusterHealth(ctx context.Context, adminAddress string) ([]ClusterHealth, error) {
+	client, baseURL := adminClient(adminAddress)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/clusters?format=json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("envoy admin returned %d: %s", resp.StatusCode, body)
+	}
+
+	clusters := &envoyadminv3.Clusters{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, clusters); err != nil {
+		return nil, err
+	}
+
+	result := make([]ClusterHealth, 0, len(clusters.ClusterStatuses))
+	for _, cluster := range clusters.ClusterStatuses {
+		health := ClusterHealth{Name: cluster.Name, Hosts: make([]HostHealth, 0, len(cluster.HostStatuses))}
+		for _, host := range cluster.HostStatuses {
+			status := host.GetHealthStatus()
+			health.Hosts = append(health.Hosts, HostHealth{
+				Address: fmt.Sprintf("%s:%d", host.GetAddress().GetSocketAddress().GetAddress(),
+					host.GetAddress().GetSocketAddress().GetPortValue()),
+				Healthy: !status.GetFailedActiveHealthCheck() && !status.GetFailedOutlierCheck() &&
+					(status.GetEdsHealthStatus() == envoycorev3.HealthStatus_UNKNOWN || status.GetEdsHealthStatus() == envoycorev3.HealthStatus_HEALTHY),
+				FailedActiveHealthCheck:  status.GetFailedActiveHealthCheck(),
+				FailedOutlierCheck:       status.GetFailedOutlierCheck(),
+				PendingActiveHealthCheck: status.GetPendingActiveHc(),
+			})
+		}
+		result = append(result, health)
+	}
+	return result, nil
+}
+
+// NewHealthHandler returns an HTTP handler serving the health of the Envoy clusters as JSON, for debugging purposes.
+func NewHealthHandler(adminAddress string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		health, err := FetchClusterHealth(ctx, adminAddress)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(health)
+	})
+}
+
+func adminClient(adminAddress string) (*http.Client, string) {
+	if !strings.HasPrefix(adminAddress, "unix://") {
+		return http.DefaultClient, strings.TrimSuffix(adminAddress, "/")
+	}
+
+	path := strings.TrimPrefix(adminAddress, "unix://")
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}, "http://envoy"
+}
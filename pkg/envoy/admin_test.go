@@ -0,0 +1,79 @@
+package envoy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const testClusters = `{
+  "cluster_statuses": [
+    {
+      "name": "default/foo",
+      "added_via_api": true,
+      "host_statuses": [
+        {
+          "address": {"socket_address": {"address": "10.0.0.1", "port_value": 80}},
+          "health_status": {"eds_health_status": "HEALTHY"}
+        },
+        {
+          "address": {"socket_address": {"address": "10.0.0.2", "port_value": 80}},
+          "health_status": {"failed_active_health_check": true, "eds_health_status": "HEALTHY"}
+        },
+        {
+          "address": {"socket_address": {"address": "10.0.0.3", "port_value": 443}},
+          "health_status": {"failed_outlier_check": true, "pending_active_hc": true}
+        }
+      ]
+    },
+    {"name": "access_log_collector"}
+  ]
+}`
+
+func TestHealthHandler(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/clusters" || r.URL.Query().Get("format") != "json" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(testClusters))
+	}))
+	defer admin.Close()
+
+	recorder := httptest.NewRecorder()
+	NewHealthHandler(admin.URL+"/").ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/envoy/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var health []ClusterHealth
+	if err := json.Unmarshal(recorder.Body.Bytes(), &health); err != nil {
+		t.Fatalf("unexpected error decoding %s: %v", recorder.Body, err)
+	}
+	expected := []ClusterHealth{
+		{Name: "default/foo", Hosts: []HostHealth{
+			{Address: "10.0.0.1:80", Healthy: true},
+			{Address: "10.0.0.2:80", FailedActiveHealthCheck: true},
+			{Address: "10.0.0.3:443", FailedOutlierCheck: true, PendingActiveHealthCheck: true},
+		}},
+		{Name: "access_log_collector", Hosts: []HostHealth{}},
+	}
+	if !reflect.DeepEqual(health, expected) {
+		t.Errorf("expected the health %+v, got %+v", expected, health)
+	}
+}
+
+func TestHealthHandlerAdminError(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer admin.Close()
+
+	recorder := httptest.NewRecorder()
+	NewHealthHandler(admin.URL).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/envoy/health", nil))
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("expected status 502 when the Envoy admin API fails, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,199 @@
+package envoy
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// WarningsAnnotation is set by the controller on the root Ingresses with invalid annotations or paths, or conflicting
+// with other Ingresses, listing the problems found when serving them, one per line.
+const WarningsAnnotation = annotationPrefix + "warnings"
+
+// Annotations set on root Ingresses to configure how they are served by Envoy.
+const (
+	annotationPrefix = "ingress.kcp.dev/"
+
+	// LoadBalancingAnnotation selects how the traffic is balanced between the clusters of the Ingress, one of
+	// LoadBalancingRoundRobin (default), LoadBalancingLocalityWeighted or LoadBalancingFailover.
+	LoadBalancingAnnotation = annotationPrefix + "load-balancing"
+
+	// TrafficSplitAnnotation splits the traffic between the clusters of the Ingress by weight, as a comma separated
+	// list of cluster=weight pairs, like "cluster-a=90,cluster-b=10". Clusters not listed get no traffic, unless none of
+	// the listed clusters has a ready leaf, when the traffic isn't split.
+	TrafficSplitAnnotation = annotationPrefix + "traffic-split"
+
+	// FleetsAnnotation is a comma separated list of the Envoy fleets serving the Ingress, by name, like
+	// "edge-eu,edge-us". Ingresses without it, or listing no fleet, are served by all the fleets.
+	FleetsAnnotation = annotationPrefix + "fleets"
+
+	// AccessLogSamplingAnnotation is the percentage of the requests to the Ingress that are logged, from 0 to 100.
+	// "0" opts the Ingress out of access logging.
+	AccessLogSamplingAnnotation = annotationPrefix + "access-log-sampling"
+
+	// Local rate limiting of the requests to the Ingress, with a token bucket getting RateLimitRequestsAnnotation
+	// tokens every RateLimitIntervalAnnotation (1s by default), up to RateLimitBurstAnnotation tokens (the requests by
+	// default). Requests without tokens get the RateLimitStatusAnnotation status.
+	RateLimitRequestsAnnotation = annotationPrefix + "rate-limit-requests"
+	RateLimitIntervalAnnotation = annotationPrefix + "rate-limit-interval"
+	RateLimitBurstAnnotation    = annotationPrefix + "rate-limit-burst"
+	RateLimitStatusAnnotation   = annotationPrefix + "rate-limit-status"
+	// RateLimitScopeAnnotation is RateLimitScopeRoute (default) to give each route its own token bucket, or
+	// RateLimitScopeHost to share one between all the routes of each host.
+	RateLimitScopeAnnotation = annotationPrefix + "rate-limit-scope"
+
+	// ExtAuthzAnnotation set to "true" checks the requests to the Ingress with the external authorization service.
+	ExtAuthzAnnotation = annotationPrefix + "ext-authz"
+	// ExtAuthzContextAnnotation is a comma separated list of key=value pairs sent to the gRPC authorization service
+	// along with the requests, like "tenant=acme".
+	ExtAuthzContextAnnotation = annotationPrefix + "ext-authz-context"
+	// ExtAuthzFailureModeAnnotation is ExtAuthzFailureModeDeny or ExtAuthzFailureModeAllow, overriding the controller
+	// configuration.
+	ExtAuthzFailureModeAnnotation = annotationPrefix + "ext-authz-failure-mode"
+
+	// CORS policy of the routes of the Ingress, enabled by CORSAllowOriginsAnnotation or CORSAllowOriginRegexAnnotation.
+	// CORSAllowOriginsAnnotation is a comma separated list of exact origins, like "https://app.example.com", or "*" to
+	// allow any origin. CORSAllowOriginRegexAnnotation is a RE2 regular expression matching the full origin.
+	CORSAllowOriginsAnnotation     = annotationPrefix + "cors-allow-origins"
+	CORSAllowOriginRegexAnnotation = annotationPrefix + "cors-allow-origin-regex"
+	// CORSAllowMethodsAnnotation, CORSAllowHeadersAnnotation and CORSExposeHeadersAnnotation are comma separated lists.
+	// The methods default to DefaultCORSAllowMethods.
+	CORSAllowMethodsAnnotation  = annotationPrefix + "cors-allow-methods"
+	CORSAllowHeadersAnnotation  = annotationPrefix + "cors-allow-headers"
+	CORSExposeHeadersAnnotation = annotationPrefix + "cors-expose-headers"
+	// CORSAllowCredentialsAnnotation set to "true" allows requests with credentials, it can't be used with any origin.
+	CORSAllowCredentialsAnnotation = annotationPrefix + "cors-allow-credentials"
+	// CORSMaxAgeAnnotation is how long browsers can cache the preflight responses, as a duration like "24h".
+	CORSMaxAgeAnnotation = annotationPrefix + "cors-max-age"
+
+	// Headers of the requests and responses of the routes of the Ingress. RequestHeadersAddAnnotation and
+	// ResponseHeadersAddAnnotation are comma separated lists of name=value pairs, like "x-env=prod", replacing the
+	// headers with the same name. RequestHeadersRemoveAnnotation and ResponseHeadersRemoveAnnotation are comma separated
+	// lists of header names.
+	RequestHeadersAddAnnotation     = annotationPrefix + "request-headers-add"
+	RequestHeadersRemoveAnnotation  = annotationPrefix + "request-headers-remove"
+	ResponseHeadersAddAnnotation    = annotationPrefix + "response-headers-add"
+	ResponseHeadersRemoveAnnotation = annotationPrefix + "response-headers-remove"
+	// HostRewriteAnnotation is the host the requests are forwarded with, either a literal host or HostRewriteUpstream.
+	HostRewriteAnnotation = annotationPrefix + "host-rewrite"
+	// RewriteTargetAnnotation is the path the matched path of the requests is replaced with, like "/" to strip the path
+	// prefix an application doesn't know about.
+	RewriteTargetAnnotation = annotationPrefix + "rewrite-target"
+
+	// Redirects of the routes of the Ingress, sent instead of forwarding the requests to its clusters.
+	// RedirectHostAnnotation is the host, with an optional port, the requests are redirected to.
+	// RedirectPathAnnotation replaces the matched path of the requests, like RewriteTargetAnnotation.
+	// RedirectCodeAnnotation is the status of the redirects, one of 301 (default), 302, 307 or 308.
+	RedirectHostAnnotation = annotationPrefix + "redirect-host"
+	RedirectPathAnnotation = annotationPrefix + "redirect-path"
+	RedirectCodeAnnotation = annotationPrefix + "redirect-code"
+	// HTTPSRedirectAnnotation set to "false" serves the plaintext requests to the hosts of the Ingress covered by its TLS
+	// entries, instead of redirecting them to HTTPS.
+	HTTPSRedirectAnnotation = annotationPrefix + "https-redirect"
+
+	// BackendProtocolAnnotation is the protocol spoken to the cluster gateways, one of BackendProtocolHTTP,
+	// BackendProtocolH2C, BackendProtocolH2 or BackendProtocolGRPC. It defaults to the appProtocol of the backend
+	// Service ports.
+	BackendProtocolAnnotation = annotationPrefix + "backend-protocol"
+
+	// Active health checking of the cluster gateways, overriding the controller configuration.
+	// HealthCheckProtocolAnnotation is one of HealthCheckHTTP, HealthCheckTCP or HealthCheckNone.
+	HealthCheckProtocolAnnotation = annotationPrefix + "health-check-protocol"
+	// HealthCheckPathAnnotation and HealthCheckHostAnnotation set the request of HTTP health checks. The host defaults to
+	// the first host of the Ingress rules that isn't a wildcard, or else to the controller configuration. Without any,
+	// the gateways are checked with their hostname, and the ones reached by IP with the Envoy cluster name.
+	HealthCheckPathAnnotation = annotationPrefix + "health-check-path"
+	HealthCheckHostAnnotation = annotationPrefix + "health-check-host"
+	// HealthCheckIntervalAnnotation and HealthCheckTimeoutAnnotation are durations, like "10s".
+	HealthCheckIntervalAnnotation = annotationPrefix + "health-check-interval"
+	HealthCheckTimeoutAnnotation  = annotationPrefix + "health-check-timeout"
+	// HealthCheckHealthyThresholdAnnotation and HealthCheckUnhealthyThresholdAnnotation are the number of consecutive
+	// successful or failed health checks to mark a gateway healthy or unhealthy.
+	HealthCheckHealthyThresholdAnnotation   = annotationPrefix + "health-check-healthy-threshold"
+	HealthCheckUnhealthyThresholdAnnotation = annotationPrefix + "health-check-unhealthy-threshold"
+	// HealthCheckExpectedStatusesAnnotation is a comma separated list of HTTP statuses or inclusive ranges of statuses
+	// considered healthy, like "200-399,404".
+	HealthCheckExpectedStatusesAnnotation = annotationPrefix + "health-check-expected-statuses"
+
+	// Outlier detection of the cluster gateways, overriding the controller configuration.
+	// OutlierConsecutive5xxAnnotation is the number of consecutive 5xx responses to eject a gateway, 0 disables it.
+	OutlierConsecutive5xxAnnotation = annotationPrefix + "outlier-consecutive-5xx"
+	// OutlierIntervalAnnotation and OutlierBaseEjectionTimeAnnotation are durations, like "30s".
+	OutlierIntervalAnnotation         = annotationPrefix + "outlier-interval"
+	OutlierBaseEjectionTimeAnnotation = annotationPrefix + "outlier-base-ejection-time"
+	// OutlierMaxEjectionPercentAnnotation is the maximum percentage of gateways that can be ejected, from 0 to 100.
+	OutlierMaxEjectionPercentAnnotation = annotationPrefix + "outlier-max-ejection-percent"
+
+	// Circuit breakers of the Envoy clusters of the Ingress, overriding the controller configuration.
+	MaxConnectionsAnnotation     = annotationPrefix + "max-connections"
+	MaxPendingRequestsAnnotation = annotationPrefix + "max-pending-requests"
+	MaxRequestsAnnotation        = annotationPrefix + "max-requests"
+
+	// Timeouts of the requests to the cluster gateways, overriding the controller configuration, as durations like "30s".
+	// RequestTimeoutAnnotation is the timeout of the whole request including retries, "0s" disables it.
+	RequestTimeoutAnnotation = annotationPrefix + "request-timeout"
+	// IdleTimeoutAnnotation is the timeout of a request without activity, for long-polling or streaming APIs.
+	IdleTimeoutAnnotation    = annotationPrefix + "idle-timeout"
+	ConnectTimeoutAnnotation = annotationPrefix + "connect-timeout"
+
+	// Retries of the requests to the cluster gateways, overriding the controller configuration.
+	// RetryOnAnnotation is a comma separated list of Envoy retry conditions, like "5xx,reset". Empty disables retries.
+	RetryOnAnnotation       = annotationPrefix + "retry-on"
+	NumRetriesAnnotation    = annotationPrefix + "num-retries"
+	PerTryTimeoutAnnotation = annotationPrefix + "per-try-timeout"
+	// RetriableStatusCodesAnnotation is a comma separated list of the statuses retried with the
+	// "retriable-status-codes" condition, like "409,503".
+	RetriableStatusCodesAnnotation = annotationPrefix + "retriable-status-codes"
+	// RetriableHeadersAnnotation is a comma separated list of the response headers retried with the
+	// "retriable-headers" condition, like "x-retry".
+	RetriableHeadersAnnotation = annotationPrefix + "retriable-headers"
+)
+
+const (
+	// LoadBalancingRoundRobin balances the traffic between all the endpoints of all the clusters.
+	LoadBalancingRoundRobin = "round-robin"
+	// LoadBalancingLocalityWeighted balances the traffic evenly between the clusters, whatever their number of endpoints.
+	LoadBalancingLocalityWeighted = "locality-weighted"
+	// LoadBalancingFailover sends the traffic to the clusters nearest to Envoy, and only spills over to the farther ones
+	// when they are unhealthy.
+	LoadBalancingFailover = "failover"
+)
+
+const (
+	// BackendProtocolHTTP is HTTP/1.1.
+	BackendProtocolHTTP = "http"
+	// BackendProtocolH2C is HTTP/2 without TLS, with prior knowledge.
+	BackendProtocolH2C = "h2c"
+	// BackendProtocolH2 is HTTP/2 over TLS.
+	BackendProtocolH2 = "h2"
+	// BackendProtocolGRPC is gRPC, over HTTP/2 without TLS unless the gateway only exposes HTTPS.
+	BackendProtocolGRPC = "grpc"
+)
+
+// parseUint32Annotation sets the value from the annotation, if the Ingress has it.
+func parseUint32Annotation(annotations map[string]string, name string, value *uint32) error {
+	v, ok := annotations[name]
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	*value = uint32(parsed)
+	return nil
+}
+
+// parseDurationAnnotation sets the value from the annotation, if the Ingress has it.
+func parseDurationAnnotation(annotations map[string]string, name string, value *time.Duration) error {
+	v, ok := annotations[name]
+	if !ok {
+		return nil
+	}
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	*value = parsed
+	return nil
+}
@@ -0,0 +1,258 @@
+package envoy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyendpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoyextauthzv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_authz/v3"
+	envoyfilterhcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	networkingv1 "k8s.io/api/networking/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// ExtAuthzNone disables external authorization.
+	ExtAuthzNone = "none"
+	// ExtAuthzGRPC checks the requests with the Envoy gRPC authorization API.
+	ExtAuthzGRPC = "grpc"
+	// ExtAuthzHTTP checks the requests by forwarding their headers to an HTTP service.
+	ExtAuthzHTTP = "http"
+
+	// ExtAuthzFailureModeDeny rejects the requests when the authorization service can't be reached.
+	ExtAuthzFailureModeDeny = "deny"
+	// ExtAuthzFailureModeAllow lets the requests through when the authorization service can't be reached.
+	ExtAuthzFailureModeAllow = "allow"
+
+	// The failure mode is set on the filter, so there is a filter per failure mode, and the routes enable the one of
+	// their Ingress.
+	extAuthzFilter         = "envoy.filters.http.ext_authz"
+	extAuthzFailOpenFilter = "envoy.filters.http.ext_authz.failure_mode_allow"
+	extAuthzServiceCluster = "ext_authz"
+	extAuthzStatPrefix     = "ext_authz"
+	extAuthzFailOpenPrefix = "ext_authz_failure_mode_allow"
+)
+
+// ExtAuthzConfig configures the external authorization service checking the requests of the Ingresses enabling it.
+type ExtAuthzConfig struct {
+	// Protocol is one of ExtAuthzNone, ExtAuthzGRPC or ExtAuthzHTTP.
+	Protocol string
+	// Address is the host:port of the authorization service.
+	Address string
+	// PathPrefix is prepended to the path of the requests sent to an HTTP authorization service.
+	PathPrefix string
+	// Timeout of the authorization checks.
+	Timeout time.Duration
+	// FailureMode is the default failure mode of the Ingresses, ExtAuthzFailureModeDeny or ExtAuthzFailureModeAllow.
+	FailureMode string
+}
+
+// Validate returns an error if the configuration can't be translated.
+func (c ExtAuthzConfig) Validate() error {
+	switch c.Protocol {
+	case ExtAuthzNone:
+		return nil
+	case ExtAuthzGRPC, ExtAuthzHTTP:
+	default:
+		return fmt.Errorf("unknown external authorization protocol %q", c.Protocol)
+	}
+	if _, port, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("invalid external authorization address: %v", err)
+	} else if _, err := strconv.ParseUint(port, 10, 32); err != nil {
+		return fmt.Errorf("invalid external authorization port: %v", err)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("external authorization timeout must be positive")
+	}
+	if c.FailureMode != ExtAuthzFailureModeDeny && c.FailureMode != ExtAuthzFailureModeAllow {
+		return fmt.Errorf("unknown external authorization failure mode %q", c.FailureMode)
+	}
+	return nil
+}
+
+// errExtAuthzUnavailable is returned for the Ingresses asking for authorization when there is no authorization
+// service. Their requests are denied.
+var errExtAuthzUnavailable = errors.New("no external authorization service is configured")
+
+// extAuthzRouteConfig returns the per-route configuration of the external authorization filters for the Ingress, or
+// nil if the Ingress doesn't enable it. Invalid settings fall back to the defaults, and are reported in the returned
+// error, as an Ingress asking for authorization must not be served without it. For the same reason,
+// errExtAuthzUnavailable is returned when there is no authorization service.
+func (t *translator) extAuthzRouteConfig(ingress networkingv1.Ingress) (map[string]*anypb.Any, error) {
+	value, ok := ingress.Annotations[ExtAuthzAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	var errs []error
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid %s: %v", ExtAuthzAnnotation, err))
+	} else if !enabled {
+		return nil, nil
+	}
+
+	if t.extAuthz.Protocol == ExtAuthzNone {
+		return nil, errExtAuthzUnavailable
+	}
+
+	failureMode := t.extAuthz.FailureMode
+	if mode, ok := ingress.Annotations[ExtAuthzFailureModeAnnotation]; ok {
+		if mode == ExtAuthzFailureModeDeny || mode == ExtAuthzFailureModeAllow {
+			failureMode = mode
+		} else {
+			errs = append(errs, fmt.Errorf("unknown %s %q", ExtAuthzFailureModeAnnotation, mode))
+		}
+	}
+	filter := extAuthzFilter
+	if failureMode == ExtAuthzFailureModeAllow {
+		filter = extAuthzFailOpenFilter
+	}
+
+	context, err := parseContextExtensions(ingress.Annotations[ExtAuthzContextAnnotation])
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	settings, err := marshalAny(&envoyextauthzv3.ExtAuthzPerRoute{
+		Override: &envoyextauthzv3.ExtAuthzPerRoute_CheckSettings{
+			CheckSettings: &envoyextauthzv3.CheckSettings{ContextExtensions: context},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]*anypb.Any{filter: settings}, utilerrors.NewAggregate(errs)
+}
+
+// setRouteDenied makes the route deny all its requests.
+func setRouteDenied(route *envoyroutev3.Route) {
+	route.Action = &envoyroutev3.Route_DirectResponse{
+		DirectResponse: &envoyroutev3.DirectResponseAction{Status: http.StatusForbidden},
+	}
+}
+
+// parseContextExtensions parses a comma separated list of key=value pairs.
+func parseContextExtensions(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	context := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid %s entry %q, expected key=value", ExtAuthzContextAnnotation, pair)
+		}
+		context[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return context, nil
+}
+
+// setRouteExtAuthz enables the external authorization of the route.
+func setRouteExtAuthz(route *envoyroutev3.Route, config map[string]*anypb.Any) {
+	if route.TypedPerFilterConfig == nil {
+		route.TypedPerFilterConfig = map[string]*anypb.Any{}
+	}
+	for filter, settings := range config {
+		route.TypedPerFilterConfig[filter] = settings
+	}
+}
+
+// extAuthzVirtualHostConfig returns the per-virtual host configuration of the external authorization filters,
+// disabling them for the routes of the Ingresses that don't enable it.
+func (t *translator) extAuthzVirtualHostConfig() (map[string]*anypb.Any, error) {
+	if t.extAuthz.Protocol == ExtAuthzNone {
+		return nil, nil
+	}
+	disabled, err := marshalAny(&envoyextauthzv3.ExtAuthzPerRoute{
+		Override: &envoyextauthzv3.ExtAuthzPerRoute_Disabled{Disabled: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]*anypb.Any{extAuthzFilter: disabled, extAuthzFailOpenFilter: disabled}, nil
+}
+
+// newExtAuthzFilters returns the external authorization filters of the HTTP connection managers, one per failure mode.
+func (t *translator) newExtAuthzFilters() ([]*envoyfilterhcmv3.HttpFilter, error) {
+	if t.extAuthz.Protocol == ExtAuthzNone {
+		return nil, nil
+	}
+
+	filters := make([]*envoyfilterhcmv3.HttpFilter, 0, 2)
+	for _, filter := range []struct {
+		name, statPrefix string
+		failureModeAllow bool
+	}{
+		{extAuthzFilter, extAuthzStatPrefix, false},
+		{extAuthzFailOpenFilter, extAuthzFailOpenPrefix, true},
+	} {
+		config := &envoyextauthzv3.ExtAuthz{
+			TransportApiVersion: envoycorev3.ApiVersion_V3,
+			FailureModeAllow:    filter.failureModeAllow,
+			StatPrefix:          filter.statPrefix,
+		}
+		if t.extAuthz.Protocol == ExtAuthzGRPC {
+			config.Services = &envoyextauthzv3.ExtAuthz_GrpcService{
+				GrpcService: &envoycorev3.GrpcService{
+					TargetSpecifier: &envoycorev3.GrpcService_EnvoyGrpc_{
+						EnvoyGrpc: &envoycorev3.GrpcService_EnvoyGrpc{ClusterName: extAuthzServiceCluster},
+					},
+					Timeout: durationpb.New(t.extAuthz.Timeout),
+				},
+			}
+		} else {
+			config.Services = &envoyextauthzv3.ExtAuthz_HttpService{
+				HttpService: &envoyextauthzv3.HttpService{
+					ServerUri: &envoycorev3.HttpUri{
+						Uri:              "http://" + t.extAuthz.Address,
+						HttpUpstreamType: &envoycorev3.HttpUri_Cluster{Cluster: extAuthzServiceCluster},
+						Timeout:          durationpb.New(t.extAuthz.Timeout),
+					},
+					PathPrefix: t.extAuthz.PathPrefix,
+				},
+			}
+		}
+
+		configAny, err := marshalAny(config)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, &envoyfilterhcmv3.HttpFilter{
+			Name:       filter.name,
+			ConfigType: &envoyfilterhcmv3.HttpFilter_TypedConfig{TypedConfig: configAny},
+		})
+	}
+	return filters, nil
+}
+
+// newExtAuthzCluster returns the cluster of the external authorization service, or nil when there is none.
+func (t *translator) newExtAuthzCluster() *envoyclusterv3.Cluster {
+	if t.extAuthz.Protocol == ExtAuthzNone {
+		return nil
+	}
+	host, port, _ := net.SplitHostPort(t.extAuthz.Address)
+	portNumber, _ := strconv.ParseUint(port, 10, 32)
+
+	cluster := t.newCluster(extAuthzServiceCluster, t.timeouts.Connect, []*envoyendpointv3.LocalityLbEndpoints{{
+		LbEndpoints: []*envoyendpointv3.LbEndpoint{t.newLBEndpoint(host, uint32(portNumber), false)},
+	}}, envoyclusterv3.Cluster_STRICT_DNS)
+	if t.extAuthz.Protocol == ExtAuthzGRPC {
+		if err := t.setUpstreamProtocol(cluster, BackendProtocolGRPC, ""); err != nil {
+			log.Printf("failed to configure external authorization cluster: %v", err)
+			return nil
+		}
+	}
+	return cluster
+}
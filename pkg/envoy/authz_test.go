@@ -0,0 +1,21 @@
+package envoy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestExtAuthzWithoutService(t *testing.T) {
+	ingress := newTestIngress("foo", "foo.com")
+	ingress.Annotations[ExtAuthzAnnotation] = "true"
+	_, _, routes, warnings := newTestTranslator().translateIngress(ingress, testLeaves, nil)
+
+	if len(warnings) != 1 || warnings[0].Reason != ReasonInvalidAnnotation {
+		t.Errorf("expected an invalid annotation warning, got %v", warnings)
+	}
+	for _, r := range routes {
+		if status := r.route.GetDirectResponse().GetStatus(); status != http.StatusForbidden {
+			t.Errorf("expected the route of host %q to deny the requests, got status %d", r.host, status)
+		}
+	}
+}
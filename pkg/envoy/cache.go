@@ -1,85 +1,379 @@
 package envoy
 
 import (
+	"fmt"
 	"log"
+	"sort"
 	"sync"
 	"time"
 
-	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoyendpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
-	envoycachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
-	"github.com/google/uuid"
 	gocache "github.com/patrickmn/go-cache"
+	"google.golang.org/protobuf/proto"
+	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 const (
 	defaultCleanupInterval = 1 * time.Minute
-	NodeID                 = "kcp-ingress"
+	// NodeID is the node ID of the default Envoy fleet.
+	NodeID = "kcp-ingress"
 )
 
 type Cache struct {
-	mu         sync.Mutex
+	mu sync.Mutex
+	// push serializes building and sending the snapshots.
+	push       sync.Mutex
 	ingresses  *gocache.Cache
+	secrets    *gocache.Cache
 	translator *translator
+	// fleets are the Envoy fleets, each one getting its own snapshot.
+	fleets []Fleet
+	// snapshots holds what the last snapshot of each fleet was built from.
+	snapshots map[string]*fleetSnapshot
+	// warnings holds the warnings found when building the last snapshots.
+	warnings map[string]struct{}
+	// translationWarnings holds the problems found when translating the cached Ingresses having some, by key.
+	translationWarnings map[string][]Warning
+	// annotations holds the WarningsAnnotation of the Ingresses with warnings by key, as reported by the last snapshots.
+	annotations map[string]string
+	// updated holds the keys of the Ingresses updated since the last snapshots, whose WarningsAnnotation is checked
+	// again.
+	updated map[string]struct{}
+	// versions holds the versions by type of resources of the last snapshot sent to each fleet.
+	versions map[string]map[resource.Type]string
 }
 
-func NewCache(translator *translator) *Cache {
+func NewCache(translator *translator, fleets []Fleet) *Cache {
+	snapshots := make(map[string]*fleetSnapshot, len(fleets))
+	for _, fleet := range fleets {
+		snapshots[fleet.Name] = newFleetSnapshot(fleet)
+	}
 	return &Cache{
-		mu:         sync.Mutex{},
-		ingresses:  gocache.New(gocache.NoExpiration, defaultCleanupInterval),
-		translator: translator,
+		mu:                  sync.Mutex{},
+		ingresses:           gocache.New(gocache.NoExpiration, defaultCleanupInterval),
+		secrets:             gocache.New(gocache.NoExpiration, defaultCleanupInterval),
+		translator:          translator,
+		fleets:              fleets,
+		snapshots:           snapshots,
+		warnings:            map[string]struct{}{},
+		translationWarnings: map[string][]Warning{},
+		annotations:         map[string]string{},
+		updated:             map[string]struct{}{},
+		versions:            map[string]map[resource.Type]string{},
 	}
 }
 
-func (c *Cache) UpdateIngress(ingress networkingv1.Ingress) {
+// cachedIngress is a root Ingress along with its translation, so only the updated Ingresses are translated again
+// when building a snapshot.
+type cachedIngress struct {
+	ingress networkingv1.Ingress
+	fleets  map[string]struct{}
+	// failover is set when the priorities of the localities of the Ingress depend on the fleet.
+	failover bool
+	// sampling is the percentage of the requests of the Ingress that are logged, nil to log them all.
+	sampling  *uint32
+	clusters  []cachetypes.Resource
+	endpoints []cachetypes.Resource
+	// clusterVersions and endpointVersions are the versions of the clusters and endpoints by name.
+	clusterVersions  map[string]string
+	endpointVersions map[string]string
+	routes           []hostRoute
+	tlsChains        []tlsFilterChain
+	warnings         []Warning
+}
+
+// UpdateIngress translates a root Ingress, along with the leaves that get its traffic and its backend Services, and
+// stores it in the cache. It returns the problems found when translating it, the host conflicts with the other Ingresses
+// are only found when building the snapshots.
+func (c *Cache) UpdateIngress(ingress networkingv1.Ingress, leaves []Leaf, services []v1.Service) []Warning {
+	cached := cachedIngress{ingress: ingress, tlsChains: c.translator.translateTLS(ingress)}
+	cached.clusters, cached.endpoints, cached.routes, cached.warnings = c.translator.translateIngress(ingress, leaves, services)
+
+	fleets, err := ingressFleets(ingress, fleetNames(c.fleets))
+	if err != nil {
+		cached.warnings = append(cached.warnings, newWarning(ingress, ReasonInvalidAnnotation, "%v", err))
+	}
+	cached.fleets = fleets
+	// Invalid modes are reported by translateIngress.
+	mode, _ := loadBalancingMode(ingress)
+	cached.failover = mode == LoadBalancingFailover
+	// Invalid samplings are reported by translateIngress.
+	cached.sampling, _ = accessLogSampling(ingress)
+	cached.clusterVersions, cached.endpointVersions = resourceVersions(cached.clusters), resourceVersions(cached.endpoints)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.ingresses.Delete(ingressToKey(ingress))
-	c.ingresses.Add(ingressToKey(ingress), ingress, gocache.NoExpiration)
+	key := ingressToKey(ingress)
+	c.ingresses.Set(key, cached, gocache.NoExpiration)
+	c.markDirty(key)
+	if len(cached.warnings) > 0 {
+		c.translationWarnings[key] = cached.warnings
+	} else {
+		delete(c.translationWarnings, key)
+	}
+	c.updated[key] = struct{}{}
+	c.deleteUnusedSecrets()
+	return append([]Warning(nil), cached.warnings...)
 }
 
 func (c *Cache) DeleteIngress(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.ingresses.Delete(key)
+	c.markDirty(key)
+	delete(c.translationWarnings, key)
+	delete(c.annotations, key)
+	delete(c.updated, key)
+	c.deleteUnusedSecrets()
+}
+
+// markDirty records that the Ingress was updated or deleted, so the next snapshots translate it again. c.mu must be
+// held.
+func (c *Cache) markDirty(key string) {
+	for _, s := range c.snapshots {
+		s.dirty[key] = struct{}{}
+	}
 }
 
-func (c *Cache) ToEnvoySnapshot() cache.Snapshot {
+// UpdateSecret stores a TLS Secret referenced by the Ingresses in the cache. It's dropped when no cached Ingress
+// references it anymore, so it has to be stored before the Ingresses referencing it are updated.
+func (c *Cache) UpdateSecret(secret v1.Secret) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.secrets.Set(secretToKey(secret.Namespace, secret.ClusterName, secret.Name), secret, gocache.NoExpiration)
+}
 
-	clustersResources := make([]cachetypes.Resource, 0)
-	virtualhosts := make([]*envoyroutev3.VirtualHost, 0)
+func (c *Cache) DeleteSecret(namespace, clusterName, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.secrets.Delete(secretToKey(namespace, clusterName, name))
+}
 
-	for _, ingress := range c.ingresses.Items() {
-		ingclusters, ingvhosts := c.translator.translateIngress(ingress.Object.(networkingv1.Ingress))
-		clustersResources = append(clustersResources, ingclusters...)
-		virtualhosts = append(virtualhosts, ingvhosts...)
+// deleteUnusedSecrets drops the Secrets that none of the cached Ingresses references anymore. c.mu must be held.
+func (c *Cache) deleteUnusedSecrets() {
+	used := map[string]struct{}{}
+	for _, item := range c.ingresses.Items() {
+		for _, chain := range item.Object.(cachedIngress).tlsChains {
+			used[chain.secretName] = struct{}{}
+		}
+	}
+	for key := range c.secrets.Items() {
+		if _, ok := used[key]; !ok {
+			c.secrets.Delete(key)
+		}
 	}
+}
+
+// PushSnapshots builds a new snapshot for each fleet from the cached Ingresses it serves, and sends the ones that
+// changed since the last snapshot sent to the fleet with push. Building and sending the snapshots is serialized, so an
+// older snapshot is never sent after a newer one, and the versions of a fleet are only recorded once its snapshot was
+// sent. It also returns the warnings about the Ingresses that were not found when building the previous snapshots, and
+// the current warnings of the Ingresses whose WarningsAnnotation doesn't list them anymore, host conflicts included.
+func (c *Cache) PushSnapshots(push func(fleet string, snapshot cache.Snapshot) error) ([]Warning, []IngressWarnings, error) {
+	c.push.Lock()
+	defer c.push.Unlock()
+
+	snapshots, versions, warnings, changed := c.toEnvoySnapshots()
 
-	routeConfig := c.translator.newRouteConfig("defaultroute", virtualhosts)
-	hcm := c.translator.newHTTPConnectionManager(routeConfig.Name)
-	listener, _ := c.translator.newHTTPListener(hcm)
+	var errs []error
+	for _, fleet := range c.fleets {
+		snapshot, ok := snapshots[fleet.Name]
+		if !ok {
+			continue
+		}
+		if err := push(fleet.Name, snapshot); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set snapshot of fleet %s: %v", fleet.Name, err))
+			continue
+		}
+		c.mu.Lock()
+		c.versions[fleet.Name] = versions[fleet.Name]
+		c.mu.Unlock()
+	}
+	return warnings, changed, utilerrors.NewAggregate(errs)
+}
 
-	res := make(map[resource.Type][]cachetypes.Resource, 0)
+// toEnvoySnapshots builds a new snapshot for each fleet, and returns the ones that changed since the last snapshots
+// sent, along with their versions by type of resources, the new warnings, and the Ingresses whose warnings changed.
+// Only the resources of the Ingresses updated since the last snapshots are built again, see fleetSnapshot.
+func (c *Cache) toEnvoySnapshots() (map[string]cache.Snapshot, map[string]map[resource.Type]string, []Warning, []IngressWarnings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	res[resource.RouteType] = []cachetypes.Resource{routeConfig}
-	res[resource.ListenerType] = []cachetypes.Resource{listener}
-	res[resource.ClusterType] = clustersResources
+	conflicts := make([]Warning, 0)
+	snapshots := make(map[string]cache.Snapshot, len(c.fleets))
+	fleetVersions := make(map[string]map[resource.Type]string, len(c.fleets))
+	for _, fleet := range c.fleets {
+		snapshot, versions, fleetConflicts, err := c.toFleetSnapshot(c.snapshots[fleet.Name])
+		conflicts = append(conflicts, fleetConflicts...)
+		if err != nil {
+			log.Printf("failed to create snapshot of fleet %s: %v", fleet.Name, err)
+			continue
+		}
+		if !sameVersions(versions, c.versions[fleet.Name]) {
+			snapshots[fleet.Name] = snapshot
+			fleetVersions[fleet.Name] = versions
+		}
+	}
 
-	newSnapshot, err := envoycachev3.NewSnapshot(
-		uuid.NewString(),
-		res,
-	)
-	if err != nil {
-		log.Printf("failed to create snapshot: %v", err)
+	warnings, changed := c.updateWarnings(conflicts)
+	return snapshots, fleetVersions, warnings, changed
+}
+
+// fleetLoadAssignments returns the clusters and endpoints of an Ingress failing over, with the priorities of their
+// localities set for the fleet. The cached resources are shared by all the fleets, so they are cloned.
+func fleetLoadAssignments(clusters, endpoints []cachetypes.Resource, fleet Fleet) ([]cachetypes.Resource, []cachetypes.Resource) {
+	fleetClusters := make([]cachetypes.Resource, 0, len(clusters))
+	for _, r := range clusters {
+		cluster := proto.Clone(r).(*envoyclusterv3.Cluster)
+		// EDS clusters have their endpoints published on their own.
+		if cluster.LoadAssignment != nil {
+			setFailoverPriorities(cluster.LoadAssignment.Endpoints, fleet)
+		}
+		fleetClusters = append(fleetClusters, cluster)
 	}
-	return newSnapshot
+	fleetEndpoints := make([]cachetypes.Resource, 0, len(endpoints))
+	for _, r := range endpoints {
+		assignment := proto.Clone(r).(*envoyendpointv3.ClusterLoadAssignment)
+		setFailoverPriorities(assignment.Endpoints, fleet)
+		fleetEndpoints = append(fleetEndpoints, assignment)
+	}
+	return fleetClusters, fleetEndpoints
+}
+
+// updateWarnings records the warnings of the current snapshots, made of the translation warnings and the host
+// conflicts, and returns the ones that are new, along with the Ingresses whose warnings changed. c.mu must be held.
+func (c *Cache) updateWarnings(conflicts []Warning) ([]Warning, []IngressWarnings) {
+	keys := make([]string, 0, len(c.translationWarnings))
+	for key := range c.translationWarnings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	warnings := make([]Warning, 0, len(conflicts))
+	for _, key := range keys {
+		warnings = append(warnings, c.translationWarnings[key]...)
+	}
+	warnings = append(warnings, conflicts...)
+
+	current := make(map[string]struct{}, len(warnings))
+	ingressWarnings := map[string][]Warning{}
+	newWarnings := make([]Warning, 0)
+	for _, warning := range warnings {
+		ingressKey := ingressToKey(warning.Ingress)
+		key := ingressKey + " " + warning.Reason + " " + warning.Message
+		if _, ok := current[key]; ok {
+			// The same host conflict is found in every fleet serving the Ingresses.
+			continue
+		}
+		current[key] = struct{}{}
+		ingressWarnings[ingressKey] = append(ingressWarnings[ingressKey], warning)
+		if _, ok := c.warnings[key]; !ok {
+			newWarnings = append(newWarnings, warning)
+		}
+	}
+	c.warnings = current
+	return newWarnings, c.updateAnnotations(ingressWarnings)
+}
+
+// updateAnnotations returns the current warnings of the cached Ingresses whose WarningsAnnotation doesn't list them,
+// and records them as reported. The annotation of the Ingresses updated since the last snapshots is read from them,
+// so the ones that failed to be set are reported again. c.mu must be held.
+func (c *Cache) updateAnnotations(ingressWarnings map[string][]Warning) []IngressWarnings {
+	// The Ingresses that were updated, or that have warnings now or had some before.
+	candidates := map[string]struct{}{}
+	for key := range c.updated {
+		candidates[key] = struct{}{}
+	}
+	for key := range ingressWarnings {
+		candidates[key] = struct{}{}
+	}
+	for key := range c.annotations {
+		candidates[key] = struct{}{}
+	}
+	keys := make([]string, 0, len(candidates))
+	for key := range candidates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changed := make([]IngressWarnings, 0)
+	for _, key := range keys {
+		item, ok := c.ingresses.Get(key)
+		if !ok {
+			delete(c.annotations, key)
+			continue
+		}
+		ingress := item.(cachedIngress).ingress
+		reported := c.annotations[key]
+		if _, ok := c.updated[key]; ok {
+			reported = ingress.Annotations[WarningsAnnotation]
+		}
+		value := FormatWarnings(ingressWarnings[key])
+		if value != reported {
+			changed = append(changed, IngressWarnings{Ingress: ingress, Warnings: ingressWarnings[key]})
+		}
+		if value == "" {
+			delete(c.annotations, key)
+		} else {
+			c.annotations[key] = value
+		}
+	}
+	c.updated = map[string]struct{}{}
+	return changed
+}
+
+// dedupTLSChains drops the filter chains whose Secret is not cached, and the hosts already claimed by a previous chain,
+// as Envoy rejects listeners with overlapping filter chain matches. It returns the remaining chains and the Secrets they use.
+func (c *Cache) dedupTLSChains(chains []tlsFilterChain) ([]tlsFilterChain, []string) {
+	result := make([]tlsFilterChain, 0, len(chains))
+	secretNames := make([]string, 0)
+	claimed := map[string]struct{}{}
+	usedSecrets := map[string]struct{}{}
+	defaultChain := false
+
+	for _, chain := range chains {
+		if _, ok := c.secrets.Get(chain.secretName); !ok {
+			continue
+		}
+
+		if len(chain.hosts) == 0 {
+			if defaultChain {
+				continue
+			}
+			defaultChain = true
+		} else {
+			hosts := make([]string, 0, len(chain.hosts))
+			for _, host := range chain.hosts {
+				if _, ok := claimed[host]; ok {
+					continue
+				}
+				claimed[host] = struct{}{}
+				hosts = append(hosts, host)
+			}
+			if len(hosts) == 0 {
+				continue
+			}
+			chain.hosts = hosts
+		}
+
+		result = append(result, chain)
+		if _, ok := usedSecrets[chain.secretName]; !ok {
+			usedSecrets[chain.secretName] = struct{}{}
+			secretNames = append(secretNames, chain.secretName)
+		}
+	}
+	return result, secretNames
 }
 
 func ingressToKey(ingress networkingv1.Ingress) string {
 	return ingress.Namespace + "/" + ingress.ClusterName + "#$#" + ingress.Name
 }
+
+func secretToKey(namespace, clusterName, name string) string {
+	return namespace + "/" + clusterName + "#$#" + name
+}
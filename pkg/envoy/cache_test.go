@@ -0,0 +1,270 @@
+package envoy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestTranslator returns a translator with the default configuration of the controller flags.
+func newTestTranslator() *translator {
+	listenPort, tlsListenPort, upstreamPort, upstreamTLSPort, rateLimitStatus := uint(80), uint(443), uint(80), uint(443), uint(429)
+	ipFamily, pathType := IPFamilyIPv4, PathTypePrefix
+	return NewTranslator(&TranslatorConfig{
+		EnvoyListenPort:                &listenPort,
+		EnvoyTLSListenPort:             &tlsListenPort,
+		UpstreamPort:                   &upstreamPort,
+		UpstreamTLSPort:                &upstreamTLSPort,
+		UpstreamTLS:                    &UpstreamTLSConfig{},
+		IPFamily:                       &ipFamily,
+		ImplementationSpecificPathType: &pathType,
+		HealthCheck:                    &HealthCheckConfig{Protocol: HealthCheckNone},
+		OutlierDetection:               &OutlierDetectionConfig{Consecutive5xx: 5, Interval: 10 * time.Second, BaseEjectionTime: 30 * time.Second, MaxEjectionPercent: 50},
+		CircuitBreakers:                &CircuitBreakersConfig{MaxConnections: 1024, MaxPendingRequests: 1024, MaxRequests: 1024},
+		Timeouts:                       &TimeoutConfig{Connect: 2 * time.Second},
+		Retries:                        &RetryConfig{NumRetries: 1},
+		AccessLog:                      &AccessLogConfig{Sink: AccessLogNone},
+		RateLimitStatus:                &rateLimitStatus,
+		ExtAuthz:                       &ExtAuthzConfig{Protocol: ExtAuthzNone},
+	})
+}
+
+// newTestIngress returns a root Ingress with a Prefix path per host.
+func newTestIngress(name string, hosts ...string) networkingv1.Ingress {
+	prefix := networkingv1.PathTypePrefix
+	ingress := networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{}}}
+	for _, host := range hosts {
+		ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{Path: "/", PathType: &prefix}},
+			}},
+		})
+	}
+	return ingress
+}
+
+var testLeaves = []Leaf{{Cluster: "cluster-a", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}}}
+
+func TestPushSnapshots(t *testing.T) {
+	c := NewCache(newTestTranslator(), []Fleet{{Name: NodeID}})
+	c.UpdateIngress(newTestIngress("foo", "foo.com"), testLeaves, nil)
+
+	pushed := 0
+	push := func(fleet string, snapshot cache.Snapshot) error {
+		pushed++
+		return nil
+	}
+	fail := func(fleet string, snapshot cache.Snapshot) error {
+		return errors.New("unavailable")
+	}
+
+	if _, _, err := c.PushSnapshots(fail); err == nil {
+		t.Fatal("expected the push error to be returned")
+	}
+	if _, _, err := c.PushSnapshots(push); err != nil || pushed != 1 {
+		t.Fatalf("expected the snapshot that couldn't be pushed to be pushed again, got %d pushes and error %v", pushed, err)
+	}
+	if _, _, err := c.PushSnapshots(push); err != nil || pushed != 1 {
+		t.Fatalf("expected the unchanged snapshot not to be pushed, got %d pushes and error %v", pushed, err)
+	}
+
+	c.UpdateIngress(newTestIngress("foo", "foo.com", "bar.com"), testLeaves, nil)
+	if _, _, err := c.PushSnapshots(push); err != nil || pushed != 2 {
+		t.Fatalf("expected the changed snapshot to be pushed, got %d pushes and error %v", pushed, err)
+	}
+}
+
+func TestIncrementalSnapshots(t *testing.T) {
+	c := NewCache(newTestTranslator(), []Fleet{{Name: NodeID}})
+	unrelated := newTestIngress("bar", "bar.com")
+	c.UpdateIngress(newTestIngress("foo", "foo.com"), testLeaves, nil)
+	c.UpdateIngress(unrelated, testLeaves, nil)
+
+	var snapshots []cache.Snapshot
+	push := func(fleet string, snapshot cache.Snapshot) error {
+		snapshots = append(snapshots, snapshot)
+		return nil
+	}
+	if _, _, err := c.PushSnapshots(push); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.UpdateIngress(newTestIngress("foo", "foo.com", "baz.com"), []Leaf{{Cluster: "cluster-b", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.2"}}}}, nil)
+	if _, _, err := c.PushSnapshots(push); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("expected the updated snapshot to be pushed, got %d snapshots", len(snapshots))
+	}
+	before, after := snapshots[0], snapshots[1]
+
+	key := ingressToKey(unrelated)
+	for _, typ := range []string{resource.ClusterType, resource.EndpointType} {
+		version := before.GetVersionMap(typ)[key]
+		if version == "" || after.GetVersionMap(typ)[key] != version {
+			t.Errorf("expected the %s of the unrelated Ingress to keep its version %q, got %q", typ, version, after.GetVersionMap(typ)[key])
+		}
+		if before.GetResources(typ)[key] != after.GetResources(typ)[key] {
+			t.Errorf("expected the %s of the unrelated Ingress not to be built again", typ)
+		}
+	}
+	updated := ingressToKey(newTestIngress("foo"))
+	if before.GetVersionMap(resource.EndpointType)[updated] == after.GetVersionMap(resource.EndpointType)[updated] {
+		t.Errorf("expected the endpoints of the updated Ingress to get a new version")
+	}
+
+	// Only the virtual hosts of the updated Ingress are merged again.
+	virtualHosts := func(snapshot cache.Snapshot) map[string]*envoyroutev3.VirtualHost {
+		byHost := map[string]*envoyroutev3.VirtualHost{}
+		for _, vh := range snapshot.GetResources(resource.RouteType)["defaultroute"].(*envoyroutev3.RouteConfiguration).VirtualHosts {
+			byHost[vh.Name] = vh
+		}
+		return byHost
+	}
+	beforeHosts, afterHosts := virtualHosts(before), virtualHosts(after)
+	if beforeHosts["bar.com"] == nil || beforeHosts["bar.com"] != afterHosts["bar.com"] {
+		t.Errorf("expected the virtual host of the unrelated Ingress not to be merged again")
+	}
+	if afterHosts["baz.com"] == nil || beforeHosts["foo.com"] == afterHosts["foo.com"] {
+		t.Errorf("expected the virtual hosts of the updated Ingress to be merged again")
+	}
+
+	c.DeleteIngress(updated)
+	if _, _, err := c.PushSnapshots(push); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deleted := snapshots[len(snapshots)-1]
+	if _, ok := deleted.GetVersionMap(resource.ClusterType)[updated]; ok {
+		t.Errorf("expected the cluster of the deleted Ingress to be dropped")
+	}
+	if deleted.GetVersionMap(resource.ClusterType)[key] != before.GetVersionMap(resource.ClusterType)[key] {
+		t.Errorf("expected the cluster of the unrelated Ingress to keep its version once the other one is deleted")
+	}
+	if hosts := virtualHosts(deleted); len(hosts) != 1 || hosts["bar.com"] != beforeHosts["bar.com"] {
+		t.Errorf("expected only the virtual host of the unrelated Ingress to be left, got %v", hosts)
+	}
+}
+
+func TestUpdateIngressWarnings(t *testing.T) {
+	c := NewCache(newTestTranslator(), []Fleet{{Name: NodeID}})
+	ingress := newTestIngress("foo", "foo.com")
+	ingress.Annotations[MaxConnectionsAnnotation] = "-1"
+
+	warnings := c.UpdateIngress(ingress, testLeaves, nil)
+	if len(warnings) != 1 || warnings[0].Reason != ReasonInvalidAnnotation {
+		t.Fatalf("expected an invalid annotation warning, got %v", warnings)
+	}
+	if value := FormatWarnings(warnings); !strings.HasPrefix(value, ReasonInvalidAnnotation+": ignoring circuit breaker annotations") {
+		t.Errorf("unexpected warnings annotation %q", value)
+	}
+
+	delete(ingress.Annotations, MaxConnectionsAnnotation)
+	if warnings := c.UpdateIngress(ingress, testLeaves, nil); len(warnings) != 0 {
+		t.Errorf("expected the warnings to be cleared, got %v", warnings)
+	}
+}
+
+func TestWarningsAnnotationChanges(t *testing.T) {
+	c := NewCache(newTestTranslator(), []Fleet{{Name: NodeID}})
+	push := func(string, cache.Snapshot) error { return nil }
+	ingress := newTestIngress("foo", "foo.com")
+	ingress.Annotations[MaxConnectionsAnnotation] = "-1"
+
+	c.UpdateIngress(ingress, testLeaves, nil)
+	_, changed, err := c.PushSnapshots(push)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changed) != 1 || changed[0].Ingress.Name != "foo" || len(changed[0].Warnings) != 1 {
+		t.Fatalf("expected the warning of the Ingress to be reported, got %v", changed)
+	}
+	value := FormatWarnings(changed[0].Warnings)
+
+	if _, changed, _ := c.PushSnapshots(push); len(changed) != 0 {
+		t.Errorf("expected the reported warnings not to be reported again, got %v", changed)
+	}
+	// The annotation failed to be set, so it's reported again when the Ingress is updated.
+	c.UpdateIngress(ingress, testLeaves, nil)
+	if _, changed, _ := c.PushSnapshots(push); len(changed) != 1 {
+		t.Errorf("expected the missing annotation to be reported again, got %v", changed)
+	}
+	ingress.Annotations[WarningsAnnotation] = value
+	c.UpdateIngress(ingress, testLeaves, nil)
+	if _, changed, _ := c.PushSnapshots(push); len(changed) != 0 {
+		t.Errorf("expected the annotation to be up to date, got %v", changed)
+	}
+
+	delete(ingress.Annotations, MaxConnectionsAnnotation)
+	c.UpdateIngress(ingress, testLeaves, nil)
+	if _, changed, _ := c.PushSnapshots(push); len(changed) != 1 || len(changed[0].Warnings) != 0 {
+		t.Errorf("expected the annotation to be cleared, got %v", changed)
+	}
+}
+
+func TestHostConflictWarnings(t *testing.T) {
+	c := NewCache(newTestTranslator(), []Fleet{{Name: NodeID}, {Name: "edge"}})
+	push := func(string, cache.Snapshot) error { return nil }
+	winner := newTestIngress("winner", "foo.com")
+	winner.CreationTimestamp = metav1.NewTime(time.Unix(0, 0))
+	loser := newTestIngress("loser", "foo.com")
+	loser.CreationTimestamp = metav1.NewTime(time.Unix(60, 0))
+
+	c.UpdateIngress(winner, testLeaves, nil)
+	c.UpdateIngress(loser, testLeaves, nil)
+	warnings, changed, err := c.PushSnapshots(push)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The conflict is found by every fleet, but only reported once.
+	if len(warnings) != 1 || warnings[0].Ingress.Name != "loser" || warnings[0].Reason != ReasonHostConflict {
+		t.Errorf("expected a host conflict event on the losing Ingress, got %v", warnings)
+	}
+	if len(changed) != 1 || changed[0].Ingress.Name != "loser" || len(changed[0].Warnings) != 1 {
+		t.Fatalf("expected the host conflict to be listed in the annotation of the losing Ingress only, got %v", changed)
+	}
+	if value := FormatWarnings(changed[0].Warnings); !strings.HasPrefix(value, ReasonHostConflict+": ") {
+		t.Errorf("unexpected warnings annotation %q", value)
+	}
+
+	// The loser is served once the winner is deleted, so its annotation is cleared.
+	c.DeleteIngress(ingressToKey(winner))
+	if _, changed, _ := c.PushSnapshots(push); len(changed) != 1 || changed[0].Ingress.Name != "loser" || len(changed[0].Warnings) != 0 {
+		t.Errorf("expected the annotation of the losing Ingress to be cleared, got %v", changed)
+	}
+}
+
+func TestDeleteUnusedSecrets(t *testing.T) {
+	c := NewCache(newTestTranslator(), []Fleet{{Name: NodeID}})
+	ingress := newTestIngress("foo", "foo.com")
+	ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{"foo.com"}, SecretName: "foo-tls"}}
+	secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "foo-tls", Namespace: "default"}}
+	key := secretToKey("default", "", "foo-tls")
+
+	c.UpdateSecret(secret)
+	c.UpdateIngress(ingress, testLeaves, nil)
+	if _, ok := c.secrets.Get(key); !ok {
+		t.Fatal("expected the referenced Secret to be cached")
+	}
+
+	ingress.Spec.TLS = nil
+	c.UpdateIngress(ingress, testLeaves, nil)
+	if _, ok := c.secrets.Get(key); ok {
+		t.Error("expected the Secret to be dropped once the Ingress stops referencing it")
+	}
+
+	ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{"foo.com"}, SecretName: "foo-tls"}}
+	c.UpdateSecret(secret)
+	c.UpdateIngress(ingress, testLeaves, nil)
+	c.DeleteIngress(ingressToKey(ingress))
+	if _, ok := c.secrets.Get(key); ok {
+		t.Error("expected the Secret to be dropped once the Ingress is deleted")
+	}
+}
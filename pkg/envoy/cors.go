@@ -0,0 +1,152 @@
+package envoy
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoycorsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/cors/v3"
+	envoyfilterhcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoymatcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// DefaultCORSAllowMethods are the methods allowed by the CORS policies not setting CORSAllowMethodsAnnotation.
+const DefaultCORSAllowMethods = "GET,PUT,POST,DELETE,PATCH,OPTIONS"
+
+// httpToken matches the HTTP tokens, which method and header names are.
+var httpToken = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// corsPolicy returns the CORS policy of the Ingress from its annotations, or nil if it doesn't allow any origin.
+func corsPolicy(ingress networkingv1.Ingress) (*envoyroutev3.CorsPolicy, error) {
+	origins, hasOrigins := ingress.Annotations[CORSAllowOriginsAnnotation]
+	originRegex, hasOriginRegex := ingress.Annotations[CORSAllowOriginRegexAnnotation]
+	if !hasOrigins && !hasOriginRegex {
+		for _, annotation := range []string{CORSAllowMethodsAnnotation, CORSAllowHeadersAnnotation, CORSExposeHeadersAnnotation,
+			CORSAllowCredentialsAnnotation, CORSMaxAgeAnnotation} {
+			if _, ok := ingress.Annotations[annotation]; ok {
+				return nil, fmt.Errorf("%s is set without %s or %s", annotation, CORSAllowOriginsAnnotation, CORSAllowOriginRegexAnnotation)
+			}
+		}
+		return nil, nil
+	}
+
+	policy := &envoyroutev3.CorsPolicy{}
+
+	anyOrigin := false
+	for _, origin := range splitList(origins) {
+		if origin == "*" {
+			anyOrigin = true
+			policy.AllowOriginStringMatch = append(policy.AllowOriginStringMatch, newRegexStringMatcher(".*"))
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("invalid origin %q in %s, expected scheme://host[:port]", origin, CORSAllowOriginsAnnotation)
+		}
+		policy.AllowOriginStringMatch = append(policy.AllowOriginStringMatch, &envoymatcherv3.StringMatcher{
+			MatchPattern: &envoymatcherv3.StringMatcher_Exact{Exact: origin},
+		})
+	}
+	if hasOriginRegex {
+		if _, err := regexp.Compile(originRegex); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", CORSAllowOriginRegexAnnotation, err)
+		}
+		policy.AllowOriginStringMatch = append(policy.AllowOriginStringMatch, newRegexStringMatcher(originRegex))
+	}
+	if len(policy.AllowOriginStringMatch) == 0 {
+		return nil, fmt.Errorf("%s doesn't list any origin", CORSAllowOriginsAnnotation)
+	}
+
+	var err error
+	if policy.AllowMethods, err = corsTokens(ingress, CORSAllowMethodsAnnotation, DefaultCORSAllowMethods); err != nil {
+		return nil, err
+	}
+	if policy.AllowHeaders, err = corsTokens(ingress, CORSAllowHeadersAnnotation, ""); err != nil {
+		return nil, err
+	}
+	if policy.ExposeHeaders, err = corsTokens(ingress, CORSExposeHeadersAnnotation, ""); err != nil {
+		return nil, err
+	}
+
+	if value, ok := ingress.Annotations[CORSAllowCredentialsAnnotation]; ok {
+		credentials, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", CORSAllowCredentialsAnnotation, err)
+		}
+		// Browsers reject credentialed responses allowing any origin.
+		if credentials && anyOrigin {
+			return nil, fmt.Errorf("%s can't be set when %s allows any origin", CORSAllowCredentialsAnnotation, CORSAllowOriginsAnnotation)
+		}
+		policy.AllowCredentials = wrapperspb.Bool(credentials)
+	}
+
+	if _, ok := ingress.Annotations[CORSMaxAgeAnnotation]; ok {
+		var maxAge time.Duration
+		if err := parseDurationAnnotation(ingress.Annotations, CORSMaxAgeAnnotation, &maxAge); err != nil {
+			return nil, err
+		}
+		if maxAge < 0 {
+			return nil, fmt.Errorf("%s can't be negative", CORSMaxAgeAnnotation)
+		}
+		policy.MaxAge = strconv.FormatInt(int64(maxAge/time.Second), 10)
+	}
+
+	return policy, nil
+}
+
+// corsTokens returns the comma separated list of methods or headers of the annotation, or the default value if the
+// Ingress doesn't have it.
+func corsTokens(ingress networkingv1.Ingress, annotation, defaultValue string) (string, error) {
+	value, ok := ingress.Annotations[annotation]
+	if !ok {
+		return defaultValue, nil
+	}
+	tokens := splitList(value)
+	for _, token := range tokens {
+		if !httpToken.MatchString(token) {
+			return "", fmt.Errorf("invalid %s entry %q", annotation, token)
+		}
+	}
+	return strings.Join(tokens, ","), nil
+}
+
+// splitList splits a comma separated list, dropping the empty entries.
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func newRegexStringMatcher(regex string) *envoymatcherv3.StringMatcher {
+	return &envoymatcherv3.StringMatcher{
+		MatchPattern: &envoymatcherv3.StringMatcher_SafeRegex{
+			SafeRegex: &envoymatcherv3.RegexMatcher{
+				EngineType: &envoymatcherv3.RegexMatcher_GoogleRe2{GoogleRe2: &envoymatcherv3.RegexMatcher_GoogleRE2{}},
+				Regex:      regex,
+			},
+		},
+	}
+}
+
+// newCORSFilter returns the CORS filter of the HTTP connection managers. It doesn't do anything by itself, the CORS
+// policies are set on the routes.
+func newCORSFilter() (*envoyfilterhcmv3.HttpFilter, error) {
+	configAny, err := marshalAny(&envoycorsv3.Cors{})
+	if err != nil {
+		return nil, err
+	}
+	return &envoyfilterhcmv3.HttpFilter{
+		Name:       wellknown.CORS,
+		ConfigType: &envoyfilterhcmv3.HttpFilter_TypedConfig{TypedConfig: configAny},
+	}, nil
+}
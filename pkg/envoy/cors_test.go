@@ -0,0 +1,93 @@
+package envoy
+
+import "testing"
+
+func TestCORSPolicy(t *testing.T) {
+	ingress := newTestIngress("cors", "foo.com")
+	ingress.Annotations[CORSAllowOriginsAnnotation] = "https://foo.com, http://bar.com:8080"
+	ingress.Annotations[CORSAllowOriginRegexAnnotation] = `https://[a-z]+\.baz\.com`
+	ingress.Annotations[CORSAllowHeadersAnnotation] = "X-Foo, Content-Type"
+	ingress.Annotations[CORSAllowCredentialsAnnotation] = "true"
+	ingress.Annotations[CORSMaxAgeAnnotation] = "1h"
+
+	policy, err := corsPolicy(ingress)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(policy.AllowOriginStringMatch) != 3 {
+		t.Errorf("expected the two origins and the origin regex to be allowed, got %v", policy.AllowOriginStringMatch)
+	}
+	if policy.AllowMethods != DefaultCORSAllowMethods {
+		t.Errorf("expected the default methods %q, got %q", DefaultCORSAllowMethods, policy.AllowMethods)
+	}
+	if policy.AllowHeaders != "X-Foo,Content-Type" {
+		t.Errorf("expected the headers %q, got %q", "X-Foo,Content-Type", policy.AllowHeaders)
+	}
+	if !policy.AllowCredentials.GetValue() {
+		t.Errorf("expected the credentials to be allowed")
+	}
+	if policy.MaxAge != "3600" {
+		t.Errorf("expected the max age %q, got %q", "3600", policy.MaxAge)
+	}
+
+	if policy, err := corsPolicy(newTestIngress("no-cors", "foo.com")); policy != nil || err != nil {
+		t.Errorf("expected no CORS policy without origins, got %v, %v", policy, err)
+	}
+}
+
+func TestCORSPolicyErrors(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		annotations map[string]string
+	}{
+		{name: "credentials with any origin", annotations: map[string]string{
+			CORSAllowOriginsAnnotation:     "https://foo.com,*",
+			CORSAllowCredentialsAnnotation: "true",
+		}},
+		{name: "invalid credentials", annotations: map[string]string{
+			CORSAllowOriginsAnnotation:     "https://foo.com",
+			CORSAllowCredentialsAnnotation: "yes",
+		}},
+		{name: "origin without scheme", annotations: map[string]string{
+			CORSAllowOriginsAnnotation: "foo.com",
+		}},
+		{name: "origin with path", annotations: map[string]string{
+			CORSAllowOriginsAnnotation: "https://foo.com/bar",
+		}},
+		{name: "no origin", annotations: map[string]string{
+			CORSAllowOriginsAnnotation: " , ",
+		}},
+		{name: "invalid origin regex", annotations: map[string]string{
+			CORSAllowOriginRegexAnnotation: "https://(foo.com",
+		}},
+		{name: "negative max age", annotations: map[string]string{
+			CORSAllowOriginsAnnotation: "https://foo.com",
+			CORSMaxAgeAnnotation:       "-1s",
+		}},
+		{name: "invalid max age", annotations: map[string]string{
+			CORSAllowOriginsAnnotation: "https://foo.com",
+			CORSMaxAgeAnnotation:       "1 hour",
+		}},
+		{name: "invalid method", annotations: map[string]string{
+			CORSAllowOriginsAnnotation: "https://foo.com",
+			CORSAllowMethodsAnnotation: "GET,PO ST",
+		}},
+		{name: "methods without origin", annotations: map[string]string{
+			CORSAllowMethodsAnnotation: "GET",
+		}},
+		{name: "max age without origin", annotations: map[string]string{
+			CORSMaxAgeAnnotation: "1h",
+		}},
+		{name: "credentials without origin", annotations: map[string]string{
+			CORSAllowCredentialsAnnotation: "false",
+		}},
+	} {
+		ingress := newTestIngress("cors", "foo.com")
+		for annotation, value := range test.annotations {
+			ingress.Annotations[annotation] = value
+		}
+		if _, err := corsPolicy(ingress); err == nil {
+			t.Errorf("%s: expected an error for the annotations %v", test.name, test.annotations)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package envoy
+
+import (
+	"fmt"
+	"strings"
+
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// Fleet is a group of Envoys getting the same configuration.
+type Fleet struct {
+	// Name identifies the Envoys of the fleet, see FleetHash.
+	Name string
+	// Region and Zone are where the fleet runs, to prefer the nearest clusters when failing over.
+	Region string
+	Zone   string
+}
+
+// ParseFleets parses a comma separated list of fleets, each one optionally followed by its region and zone, like
+// "edge-eu=eu-west-1/eu-west-1a". The fleets without region get the given region and zone.
+func ParseFleets(value, region, zone string) ([]Fleet, error) {
+	fleets := make([]Fleet, 0)
+	seen := map[string]struct{}{}
+	for _, entry := range splitList(value) {
+		fleet := Fleet{Name: entry, Region: region, Zone: zone}
+		if i := strings.Index(entry, "="); i >= 0 {
+			fleet.Name = strings.TrimSpace(entry[:i])
+			locality := strings.SplitN(strings.TrimSpace(entry[i+1:]), "/", 2)
+			fleet.Region, fleet.Zone = locality[0], ""
+			if len(locality) == 2 {
+				fleet.Zone = locality[1]
+			}
+			if fleet.Region == "" {
+				return nil, fmt.Errorf("invalid fleet %q, expected name=region[/zone]", entry)
+			}
+		}
+		if fleet.Name == "" {
+			return nil, fmt.Errorf("invalid fleet %q, the name is empty", entry)
+		}
+		if _, ok := seen[fleet.Name]; ok {
+			return nil, fmt.Errorf("fleet %q is listed more than once", fleet.Name)
+		}
+		seen[fleet.Name] = struct{}{}
+		fleets = append(fleets, fleet)
+	}
+	if len(fleets) == 0 {
+		return nil, fmt.Errorf("no fleet is listed")
+	}
+	return fleets, nil
+}
+
+// FleetHash maps the Envoys to their fleet, by the value of a key of their node metadata, like their region or tenant
+// tier. Envoys are mapped by node ID when the key is empty, or when their metadata doesn't have it.
+type FleetHash struct {
+	MetadataKey string
+}
+
+// ID returns the fleet of the Envoy node.
+func (h FleetHash) ID(node *envoycorev3.Node) string {
+	if node == nil {
+		return ""
+	}
+	if h.MetadataKey != "" {
+		if value := node.GetMetadata().GetFields()[h.MetadataKey].GetStringValue(); value != "" {
+			return value
+		}
+	}
+	return node.Id
+}
+
+// fleetNames returns the names of the fleets.
+func fleetNames(fleets []Fleet) []string {
+	names := make([]string, 0, len(fleets))
+	for _, fleet := range fleets {
+		names = append(names, fleet.Name)
+	}
+	return names
+}
+
+// ingressFleets returns the fleets serving the Ingress: the ones listed in its FleetsAnnotation, or all the fleets
+// without it. Unknown fleets are ignored and reported in the returned error, as is an annotation listing no fleet,
+// which selects all the fleets like a missing one.
+func ingressFleets(ingress networkingv1.Ingress, fleets []string) (map[string]struct{}, error) {
+	selected := make(map[string]struct{}, len(fleets))
+	value, ok := ingress.Annotations[FleetsAnnotation]
+	listed := splitList(value)
+	if len(listed) == 0 {
+		for _, fleet := range fleets {
+			selected[fleet] = struct{}{}
+		}
+		if ok {
+			return selected, fmt.Errorf("%s lists no fleet, the Ingress is served by all the fleets", FleetsAnnotation)
+		}
+		return selected, nil
+	}
+
+	known := make(map[string]struct{}, len(fleets))
+	for _, fleet := range fleets {
+		known[fleet] = struct{}{}
+	}
+
+	unknown := make([]string, 0)
+	for _, fleet := range listed {
+		if _, ok := known[fleet]; !ok {
+			unknown = append(unknown, fleet)
+			continue
+		}
+		selected[fleet] = struct{}{}
+	}
+	if len(unknown) > 0 {
+		return selected, fmt.Errorf("unknown fleets %q", unknown)
+	}
+	return selected, nil
+}
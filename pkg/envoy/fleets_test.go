@@ -0,0 +1,119 @@
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyendpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestParseFleets(t *testing.T) {
+	fleets, err := ParseFleets("kcp-ingress, edge-eu=eu-west-1/eu-west-1a, edge-us=us-east-1", "eu-central-1", "eu-central-1b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Fleet{
+		{Name: "kcp-ingress", Region: "eu-central-1", Zone: "eu-central-1b"},
+		{Name: "edge-eu", Region: "eu-west-1", Zone: "eu-west-1a"},
+		{Name: "edge-us", Region: "us-east-1"},
+	}
+	if !reflect.DeepEqual(fleets, expected) {
+		t.Errorf("expected the fleets %v, got %v", expected, fleets)
+	}
+
+	for _, value := range []string{"", "edge-eu=", "=eu-west-1", "edge-eu,edge-eu=eu-west-1"} {
+		if _, err := ParseFleets(value, "", ""); err == nil {
+			t.Errorf("expected an error parsing the fleets %q", value)
+		}
+	}
+}
+
+func TestFleetHash(t *testing.T) {
+	node := &envoycorev3.Node{Id: "envoy-1", Metadata: &structpb.Struct{Fields: map[string]*structpb.Value{
+		"region": structpb.NewStringValue("edge-eu"),
+	}}}
+	for _, test := range []struct {
+		key      string
+		node     *envoycorev3.Node
+		expected string
+	}{
+		{key: "", node: node, expected: "envoy-1"},
+		{key: "region", node: node, expected: "edge-eu"},
+		{key: "tier", node: node, expected: "envoy-1"},
+		{key: "region", node: &envoycorev3.Node{Id: "envoy-2"}, expected: "envoy-2"},
+	} {
+		if fleet := (FleetHash{MetadataKey: test.key}).ID(test.node); fleet != test.expected {
+			t.Errorf("expected node %q to be in fleet %q with the metadata key %q, got %q", test.node.Id, test.expected, test.key, fleet)
+		}
+	}
+}
+
+func TestIngressFleets(t *testing.T) {
+	fleets := []string{"edge-eu", "edge-us"}
+	for _, test := range []struct {
+		annotation *string
+		selected   []string
+		err        bool
+	}{
+		{annotation: nil, selected: fleets},
+		{annotation: pointer.StringPtr("edge-eu"), selected: []string{"edge-eu"}},
+		{annotation: pointer.StringPtr(" edge-eu, edge-us "), selected: fleets},
+		{annotation: pointer.StringPtr("edge-eu,"), selected: []string{"edge-eu"}},
+		{annotation: pointer.StringPtr("edge-eu,edge-ap"), selected: []string{"edge-eu"}, err: true},
+		{annotation: pointer.StringPtr(""), selected: fleets, err: true},
+		{annotation: pointer.StringPtr(" , "), selected: fleets, err: true},
+	} {
+		ingress := newTestIngress("fleets", "foo.com")
+		if test.annotation != nil {
+			ingress.Annotations[FleetsAnnotation] = *test.annotation
+		}
+		selected, err := ingressFleets(ingress, fleets)
+		if (err != nil) != test.err {
+			t.Errorf("annotation %v: expected error %t, got %v", test.annotation, test.err, err)
+		}
+		expected := make(map[string]struct{}, len(test.selected))
+		for _, fleet := range test.selected {
+			expected[fleet] = struct{}{}
+		}
+		if !reflect.DeepEqual(selected, expected) {
+			t.Errorf("annotation %v: expected the fleets %v, got %v", test.annotation, test.selected, selected)
+		}
+	}
+}
+
+func TestFleetLoadAssignments(t *testing.T) {
+	tr := newTestTranslator()
+	ingress := newTestIngress("failover", "foo.com")
+	ingress.Annotations[LoadBalancingAnnotation] = LoadBalancingFailover
+	leaves := []Leaf{
+		{Cluster: "cluster-eu", Region: "eu-west-1", Zone: "eu-west-1a", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}},
+		{Cluster: "cluster-us", Region: "us-east-1", Zone: "us-east-1a", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.2"}}},
+	}
+	clusters, endpoints, _, _ := tr.translateIngress(ingress, leaves, nil)
+
+	for _, test := range []struct {
+		fleet      Fleet
+		priorities map[string]uint32
+	}{
+		{fleet: Fleet{Name: "edge-eu", Region: "eu-west-1"}, priorities: map[string]uint32{"cluster-eu": 0, "cluster-us": 1}},
+		{fleet: Fleet{Name: "edge-us", Region: "us-east-1"}, priorities: map[string]uint32{"cluster-eu": 1, "cluster-us": 0}},
+	} {
+		_, fleetEndpoints := fleetLoadAssignments(clusters, endpoints, test.fleet)
+		for _, locality := range fleetEndpoints[0].(*envoyendpointv3.ClusterLoadAssignment).Endpoints {
+			if expected := test.priorities[locality.Locality.SubZone]; locality.Priority != expected {
+				t.Errorf("expected priority %d for %s in fleet %s, got %d", expected, locality.Locality.SubZone, test.fleet.Name, locality.Priority)
+			}
+		}
+	}
+
+	// The cached endpoints are shared by the fleets.
+	for _, locality := range endpoints[0].(*envoyendpointv3.ClusterLoadAssignment).Endpoints {
+		if locality.Priority != 0 {
+			t.Errorf("expected the cached endpoints not to be modified, got priority %d for %s", locality.Priority, locality.Locality.SubZone)
+		}
+	}
+}
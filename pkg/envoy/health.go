@@ -0,0 +1,205 @@
+package envoy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyendpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoytypev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckNone = "none"
+)
+
+// HealthCheckConfig configures the active health checking of the cluster gateways.
+type HealthCheckConfig struct {
+	// Protocol is one of HealthCheckHTTP, HealthCheckTCP or HealthCheckNone.
+	Protocol string
+	// Path and Host are the request of HTTP health checks. Host is only used for the Ingresses without a rule host that
+	// isn't a wildcard.
+	Path string
+	Host string
+	// Interval between health checks, and Timeout of each of them.
+	Interval time.Duration
+	Timeout  time.Duration
+	// HealthyThreshold and UnhealthyThreshold are the number of consecutive successful or failed health checks to mark
+	// a gateway healthy or unhealthy.
+	HealthyThreshold   uint32
+	UnhealthyThreshold uint32
+	// ExpectedStatuses is a comma separated list of HTTP statuses or inclusive ranges of statuses considered healthy,
+	// like "200-399,404".
+	ExpectedStatuses string
+}
+
+// Validate returns an error if the configuration can't be translated.
+func (c HealthCheckConfig) Validate() error {
+	switch c.Protocol {
+	case HealthCheckHTTP, HealthCheckTCP, HealthCheckNone:
+	default:
+		return fmt.Errorf("unknown health check protocol %q", c.Protocol)
+	}
+	if c.Protocol == HealthCheckNone {
+		return nil
+	}
+	if c.Interval <= 0 || c.Timeout <= 0 {
+		return fmt.Errorf("health check interval and timeout must be positive")
+	}
+	if c.HealthyThreshold == 0 || c.UnhealthyThreshold == 0 {
+		return fmt.Errorf("health check thresholds must be positive")
+	}
+	if c.Protocol == HealthCheckHTTP {
+		if !strings.HasPrefix(c.Path, "/") {
+			return fmt.Errorf("health check path %q must start with /", c.Path)
+		}
+		if _, err := parseStatusRanges(c.ExpectedStatuses); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// healthCheckConfig returns the health check configuration of the Ingress: the controller configuration overridden by
+// the annotations of the Ingress. The host defaults to the first host of the Ingress rules that isn't a wildcard, as
+// the gateways route the requests by the hosts of the leaves, and else to the one of the controller configuration.
+func (t *translator) healthCheckConfig(ingress networkingv1.Ingress) (HealthCheckConfig, error) {
+	config := t.healthCheckDefaults(ingress)
+
+	annotations := ingress.Annotations
+	if v, ok := annotations[HealthCheckProtocolAnnotation]; ok {
+		config.Protocol = v
+	}
+	if v, ok := annotations[HealthCheckPathAnnotation]; ok {
+		config.Path = v
+	}
+	if v, ok := annotations[HealthCheckHostAnnotation]; ok {
+		config.Host = v
+	}
+	if v, ok := annotations[HealthCheckExpectedStatusesAnnotation]; ok {
+		config.ExpectedStatuses = v
+	}
+	for _, err := range []error{
+		parseDurationAnnotation(annotations, HealthCheckIntervalAnnotation, &config.Interval),
+		parseDurationAnnotation(annotations, HealthCheckTimeoutAnnotation, &config.Timeout),
+		parseUint32Annotation(annotations, HealthCheckHealthyThresholdAnnotation, &config.HealthyThreshold),
+		parseUint32Annotation(annotations, HealthCheckUnhealthyThresholdAnnotation, &config.UnhealthyThreshold),
+	} {
+		if err != nil {
+			return config, err
+		}
+	}
+
+	return config, config.Validate()
+}
+
+// healthCheckDefaults returns the health check configuration of the Ingress without its annotations.
+func (t *translator) healthCheckDefaults(ingress networkingv1.Ingress) HealthCheckConfig {
+	config := t.healthCheck
+	if host := defaultHost(ingress); host != "" {
+		config.Host = host
+	}
+	return config
+}
+
+// newHealthChecks translates the health check configuration, it returns nil if health checking is disabled.
+func newHealthChecks(config HealthCheckConfig) []*envoycorev3.HealthCheck {
+	if config.Protocol == HealthCheckNone {
+		return nil
+	}
+
+	healthCheck := &envoycorev3.HealthCheck{
+		Timeout:            durationpb.New(config.Timeout),
+		Interval:           durationpb.New(config.Interval),
+		HealthyThreshold:   wrapperspb.UInt32(config.HealthyThreshold),
+		UnhealthyThreshold: wrapperspb.UInt32(config.UnhealthyThreshold),
+	}
+
+	switch config.Protocol {
+	case HealthCheckTCP:
+		// Without payload, a successful connection is a healthy gateway.
+		healthCheck.HealthChecker = &envoycorev3.HealthCheck_TcpHealthCheck_{
+			TcpHealthCheck: &envoycorev3.HealthCheck_TcpHealthCheck{},
+		}
+	case HealthCheckHTTP:
+		// Already validated.
+		statuses, _ := parseStatusRanges(config.ExpectedStatuses)
+		healthCheck.HealthChecker = &envoycorev3.HealthCheck_HttpHealthCheck_{
+			HttpHealthCheck: &envoycorev3.HealthCheck_HttpHealthCheck{
+				Host:             config.Host,
+				Path:             config.Path,
+				ExpectedStatuses: statuses,
+			},
+		}
+	}
+
+	return []*envoycorev3.HealthCheck{healthCheck}
+}
+
+// setHealthCheckHostnames sets the host of the HTTP health checks of the gateways reached by hostname to their
+// hostname, for the clusters whose health checks have no host. Envoy uses the cluster name for the other gateways.
+func setHealthCheckHostnames(localities []*envoyendpointv3.LocalityLbEndpoints) {
+	for _, locality := range localities {
+		for _, lbEndpoint := range locality.LbEndpoints {
+			endpoint := lbEndpoint.GetEndpoint()
+			if address := endpoint.GetAddress().GetSocketAddress().GetAddress(); net.ParseIP(address) == nil {
+				endpoint.HealthCheckConfig = &envoyendpointv3.Endpoint_HealthCheckConfig{Hostname: address}
+			}
+		}
+	}
+}
+
+// parseStatusRanges parses a comma separated list of HTTP statuses or inclusive ranges of statuses.
+// An empty list means the Envoy default, only 200.
+func parseStatusRanges(value string) ([]*envoytypev3.Int64Range, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	ranges := make([]*envoytypev3.Int64Range, 0)
+	for _, item := range strings.Split(value, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(item), "-", 2)
+		start, err := parseStatus(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = parseStatus(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		if end < start {
+			return nil, fmt.Errorf("invalid HTTP status range %q", item)
+		}
+		// Envoy ranges are half-open.
+		ranges = append(ranges, &envoytypev3.Int64Range{Start: start, End: end + 1})
+	}
+	return ranges, nil
+}
+
+func parseStatus(value string) (int64, error) {
+	status, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || status < 100 || status > 599 {
+		return 0, fmt.Errorf("invalid HTTP status %q", value)
+	}
+	return status, nil
+}
+
+// defaultHost returns the first host of the Ingress rules that is not a wildcard, if any.
+func defaultHost(ingress networkingv1.Ingress) string {
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != "" && !strings.HasPrefix(rule.Host, "*") {
+			return rule.Host
+		}
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package envoy
+
+import (
+	"testing"
+	"time"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestHealthCheckHost(t *testing.T) {
+	leaves := []Leaf{
+		{Cluster: "cluster-a", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}},
+		{Cluster: "cluster-b", LoadBalancer: []v1.LoadBalancerIngress{{Hostname: "b.example.com"}}},
+	}
+
+	for _, test := range []struct {
+		name       string
+		hosts      []string
+		annotation string
+		configHost string
+		host       string
+		// gatewayHosts are the hosts the gateways are checked with when the cluster has none, by address.
+		gatewayHosts map[string]string
+	}{
+		{name: "rule host", hosts: []string{"*.foo.com", "bar.com"}, configHost: "health.com", host: "bar.com"},
+		{name: "annotation", hosts: []string{"bar.com"}, annotation: "baz.com", host: "baz.com"},
+		{name: "controller host", hosts: []string{"*.foo.com", ""}, configHost: "health.com", host: "health.com"},
+		{
+			name:         "no host",
+			hosts:        []string{"*.foo.com", ""},
+			gatewayHosts: map[string]string{"10.0.0.1": "", "b.example.com": "b.example.com"},
+		},
+	} {
+		tr := newTestTranslator()
+		tr.healthCheck = HealthCheckConfig{Protocol: HealthCheckHTTP, Path: "/", Host: test.configHost, Interval: 10 * time.Second,
+			Timeout: 2 * time.Second, HealthyThreshold: 1, UnhealthyThreshold: 1}
+		ingress := newTestIngress("health", test.hosts...)
+		if test.annotation != "" {
+			ingress.Annotations[HealthCheckHostAnnotation] = test.annotation
+		}
+
+		clusters, endpoints, _, warnings := tr.translateIngress(ingress, leaves, nil)
+
+		missing := false
+		for _, warning := range warnings {
+			missing = missing || warning.Reason == ReasonMissingHealthCheckHost
+		}
+		if missing != (test.host == "") {
+			t.Errorf("%s: unexpected warnings %v", test.name, warnings)
+		}
+
+		cluster := clusters[0].(*envoyclusterv3.Cluster)
+		if host := cluster.HealthChecks[0].GetHttpHealthCheck().GetHost(); host != test.host {
+			t.Errorf("%s: expected the health check host %q, got %q", test.name, test.host, host)
+		}
+		if len(endpoints) != 0 {
+			t.Fatalf("%s: expected the endpoints of the gateways reached by hostname to be inline", test.name)
+		}
+		for _, locality := range cluster.LoadAssignment.Endpoints {
+			for _, lbEndpoint := range locality.LbEndpoints {
+				endpoint := lbEndpoint.GetEndpoint()
+				address := endpoint.GetAddress().GetSocketAddress().GetAddress()
+				if host := endpoint.GetHealthCheckConfig().GetHostname(); host != test.gatewayHosts[address] {
+					t.Errorf("%s: expected the gateway %s to be checked with the host %q, got %q", test.name, address, test.gatewayHosts[address], host)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package envoy
+
+import (
+	"net"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyendpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+)
+
+const (
+	// IPFamilyIPv4 listens on IPv4 and only reaches the cluster gateways over IPv4.
+	IPFamilyIPv4 = "ipv4"
+	// IPFamilyIPv6 listens on IPv6 and only reaches the cluster gateways over IPv6.
+	IPFamilyIPv6 = "ipv6"
+	// IPFamilyDualStack listens on both IPv4 and IPv6, and reaches the cluster gateways over either, preferring IPv4.
+	IPFamilyDualStack = "dual"
+)
+
+// listenerAddress returns the address Envoy listens on for the given port.
+// In dual-stack, a single IPv6 socket also accepts IPv4 connections.
+func (t *translator) listenerAddress(port uint32) *envoycorev3.Address {
+	address := &envoycorev3.SocketAddress{
+		Protocol: envoycorev3.SocketAddress_TCP,
+		Address:  "0.0.0.0",
+		PortSpecifier: &envoycorev3.SocketAddress_PortValue{
+			PortValue: port,
+		},
+	}
+	switch t.ipFamily {
+	case IPFamilyIPv6:
+		address.Address = "::"
+	case IPFamilyDualStack:
+		address.Address = "::"
+		address.Ipv4Compat = true
+	}
+	return &envoycorev3.Address{
+		Address: &envoycorev3.Address_SocketAddress{SocketAddress: address},
+	}
+}
+
+// dnsLookupFamily returns how the hostnames of the cluster gateways are resolved.
+func (t *translator) dnsLookupFamily() envoyclusterv3.Cluster_DnsLookupFamily {
+	switch t.ipFamily {
+	case IPFamilyIPv6:
+		return envoyclusterv3.Cluster_V6_ONLY
+	case IPFamilyDualStack:
+		return envoyclusterv3.Cluster_V4_PREFERRED
+	}
+	return envoyclusterv3.Cluster_V4_ONLY
+}
+
+// reachable returns whether the address of a cluster gateway can be reached: hostnames always can, as they are
+// resolved following dnsLookupFamily, while IP addresses must be of the configured family.
+func (t *translator) reachable(address string) bool {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil, t.ipFamily == IPFamilyDualStack:
+		return true
+	case t.ipFamily == IPFamilyIPv6:
+		return ip.To4() == nil
+	default:
+		return ip.To4() != nil
+	}
+}
+
+// discoveryType returns EDS when all the endpoints are IP addresses, so they are used as is, and STRICT_DNS when
+// hostnames have to be resolved, as EDS endpoints can't be hostnames. The endpoints of STRICT_DNS clusters are inline,
+// so their changes still rebuild the clusters.
+func discoveryType(localities []*envoyendpointv3.LocalityLbEndpoints) envoyclusterv3.Cluster_DiscoveryType {
+	for _, locality := range localities {
+		for _, endpoint := range locality.LbEndpoints {
+			if net.ParseIP(endpoint.GetEndpoint().GetAddress().GetSocketAddress().GetAddress()) == nil {
+				return envoyclusterv3.Cluster_STRICT_DNS
+			}
+		}
+	}
+	return envoyclusterv3.Cluster_EDS
+}
@@ -0,0 +1,99 @@
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestIPFamilies(t *testing.T) {
+	// Leaves reached by IPv4, IPv6, hostname, or a mix of them.
+	leaves := []Leaf{
+		{Cluster: "cluster-a", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}},
+		{Cluster: "cluster-b", LoadBalancer: []v1.LoadBalancerIngress{{IP: "fd00::2"}}},
+		{Cluster: "cluster-c", LoadBalancer: []v1.LoadBalancerIngress{{Hostname: "c.example.com"}}},
+		{Cluster: "cluster-d", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.4"}, {IP: "fd00::4"}}},
+	}
+
+	for _, test := range []struct {
+		ipFamily string
+		// reachable are the addresses of the leaves reachable in the IP family, by leaf.
+		reachable       map[string][]string
+		dnsLookupFamily envoyclusterv3.Cluster_DnsLookupFamily
+		listenerAddress string
+	}{
+		{
+			ipFamily: IPFamilyIPv4,
+			reachable: map[string][]string{
+				"cluster-a": {"10.0.0.1"},
+				"cluster-c": {"c.example.com"},
+				"cluster-d": {"10.0.0.4"},
+			},
+			dnsLookupFamily: envoyclusterv3.Cluster_V4_ONLY,
+			listenerAddress: "0.0.0.0",
+		},
+		{
+			ipFamily: IPFamilyIPv6,
+			reachable: map[string][]string{
+				"cluster-b": {"fd00::2"},
+				"cluster-c": {"c.example.com"},
+				"cluster-d": {"fd00::4"},
+			},
+			dnsLookupFamily: envoyclusterv3.Cluster_V6_ONLY,
+			listenerAddress: "::",
+		},
+		{
+			ipFamily: IPFamilyDualStack,
+			reachable: map[string][]string{
+				"cluster-a": {"10.0.0.1"},
+				"cluster-b": {"fd00::2"},
+				"cluster-c": {"c.example.com"},
+				"cluster-d": {"10.0.0.4", "fd00::4"},
+			},
+			dnsLookupFamily: envoyclusterv3.Cluster_V4_PREFERRED,
+			listenerAddress: "::",
+		},
+	} {
+		tr := newTestTranslator()
+		tr.ipFamily = test.ipFamily
+
+		if family := tr.dnsLookupFamily(); family != test.dnsLookupFamily {
+			t.Errorf("%s: expected the DNS lookup family %s, got %s", test.ipFamily, test.dnsLookupFamily, family)
+		}
+		address := tr.listenerAddress(80).GetSocketAddress()
+		if address.Address != test.listenerAddress || address.Ipv4Compat != (test.ipFamily == IPFamilyDualStack) {
+			t.Errorf("%s: unexpected listener address %v", test.ipFamily, address)
+		}
+
+		localities := tr.newLocalityLbEndpoints(leaves, LoadBalancingRoundRobin, BackendProtocolHTTP)
+		reachable := map[string][]string{}
+		for _, locality := range localities {
+			for _, endpoint := range locality.LbEndpoints {
+				address := endpoint.GetEndpoint().GetAddress().GetSocketAddress().GetAddress()
+				if !tr.reachable(address) {
+					t.Errorf("%s: unreachable address %s of %s kept", test.ipFamily, address, locality.Locality.SubZone)
+				}
+				reachable[locality.Locality.SubZone] = append(reachable[locality.Locality.SubZone], address)
+			}
+		}
+		if !reflect.DeepEqual(reachable, test.reachable) {
+			t.Errorf("%s: expected the reachable addresses %v, got %v", test.ipFamily, test.reachable, reachable)
+		}
+
+		// Hostnames have to be resolved by Envoy, while IP addresses can be published with EDS.
+		if typ := discoveryType(localities); typ != envoyclusterv3.Cluster_STRICT_DNS {
+			t.Errorf("%s: expected STRICT_DNS with a hostname leaf, got %s", test.ipFamily, typ)
+		}
+		literals := make([]Leaf, 0, len(leaves))
+		for _, leaf := range leaves {
+			if leaf.Cluster != "cluster-c" {
+				literals = append(literals, leaf)
+			}
+		}
+		if typ := discoveryType(tr.newLocalityLbEndpoints(literals, LoadBalancingRoundRobin, BackendProtocolHTTP)); typ != envoyclusterv3.Cluster_EDS {
+			t.Errorf("%s: expected EDS with IP leaves only, got %s", test.ipFamily, typ)
+		}
+	}
+}
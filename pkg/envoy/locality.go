@@ -0,0 +1,137 @@
+package envoy
+
+import (
+	"fmt"
+	"sort"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyendpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// Leaf is the part of a root Ingress synced to a given cluster.
+type Leaf struct {
+	// Cluster is the name of the kcp cluster the leaf is synced to.
+	Cluster string
+	// Region and Zone are the topology labels of the kcp cluster.
+	Region string
+	Zone   string
+	// LoadBalancer is the status of the leaf, as reported by the cluster.
+	LoadBalancer []v1.LoadBalancerIngress
+}
+
+// loadBalancingMode returns the load balancing mode of the Ingress, from its LoadBalancingAnnotation.
+func loadBalancingMode(ingress networkingv1.Ingress) (string, error) {
+	mode, ok := ingress.Annotations[LoadBalancingAnnotation]
+	if !ok {
+		return LoadBalancingRoundRobin, nil
+	}
+	switch mode {
+	case LoadBalancingRoundRobin, LoadBalancingLocalityWeighted, LoadBalancingFailover:
+		return mode, nil
+	}
+	return LoadBalancingRoundRobin, fmt.Errorf("unknown load balancing %q", mode)
+}
+
+// newLocalityLbEndpoints places the endpoints of each leaf in its own locality, tagged with the region and zone of its
+// cluster, and the cluster name as sub-zone. The priorities of the LoadBalancingFailover localities depend on the fleet
+// and are set by setFailoverPriorities.
+func (t *translator) newLocalityLbEndpoints(leaves []Leaf, mode, protocol string) []*envoyendpointv3.LocalityLbEndpoints {
+
+	// Sorted, so the generated configuration doesn't change when the order of the leaves does.
+	sorted := append([]Leaf(nil), leaves...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cluster < sorted[j].Cluster })
+
+	localities := make([]*envoyendpointv3.LocalityLbEndpoints, 0, len(sorted))
+	for _, leaf := range sorted {
+		endpoints := make([]*envoyendpointv3.LbEndpoint, 0, len(leaf.LoadBalancer))
+		for _, lb := range leaf.LoadBalancer {
+			address := lb.Hostname
+			if address == "" {
+				address = lb.IP
+			}
+			if address == "" || !t.reachable(address) {
+				continue
+			}
+			port, tls := t.upstreamPort(lb, protocol)
+			endpoints = append(endpoints, t.newLBEndpoint(address, port, tls))
+		}
+		if len(endpoints) == 0 {
+			continue
+		}
+
+		locality := &envoyendpointv3.LocalityLbEndpoints{
+			Locality: &envoycorev3.Locality{
+				Region:  leaf.Region,
+				Zone:    leaf.Zone,
+				SubZone: leaf.Cluster,
+			},
+			LbEndpoints: endpoints,
+		}
+		if mode == LoadBalancingLocalityWeighted {
+			locality.LoadBalancingWeight = wrapperspb.UInt32(1)
+		}
+		localities = append(localities, locality)
+	}
+
+	return localities
+}
+
+// setLoadBalancing configures the load balancing between the localities of the cluster.
+func (t *translator) setLoadBalancing(cluster *envoyclusterv3.Cluster, mode string) {
+	if mode == LoadBalancingLocalityWeighted {
+		cluster.CommonLbConfig = &envoyclusterv3.Cluster_CommonLbConfig{
+			LocalityConfigSpecifier: &envoyclusterv3.Cluster_CommonLbConfig_LocalityWeightedLbConfig_{
+				LocalityWeightedLbConfig: &envoyclusterv3.Cluster_CommonLbConfig_LocalityWeightedLbConfig{},
+			},
+		}
+	}
+}
+
+// setFailoverPriorities sets the priorities of the localities from how far they are from the fleet, so the nearest
+// clusters get the requests first.
+func setFailoverPriorities(localities []*envoyendpointv3.LocalityLbEndpoints, fleet Fleet) {
+	for _, locality := range localities {
+		locality.Priority = proximity(locality.Locality, fleet)
+	}
+	compactPriorities(localities)
+}
+
+// proximity returns how far a locality is from the fleet: 0 when in the same zone, 1 when in the same region, and 2
+// otherwise.
+func proximity(locality *envoycorev3.Locality, fleet Fleet) uint32 {
+	switch {
+	case fleet.Region == "":
+		return 0
+	case locality.GetRegion() == fleet.Region && (fleet.Zone == "" || locality.GetZone() == fleet.Zone):
+		return 0
+	case locality.GetRegion() == fleet.Region:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// compactPriorities renumbers the priorities of the localities, as Envoy requires them to be contiguous starting from 0.
+func compactPriorities(localities []*envoyendpointv3.LocalityLbEndpoints) {
+	priorities := make([]uint32, 0)
+	seen := map[uint32]struct{}{}
+	for _, locality := range localities {
+		if _, ok := seen[locality.Priority]; !ok {
+			seen[locality.Priority] = struct{}{}
+			priorities = append(priorities, locality.Priority)
+		}
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] < priorities[j] })
+
+	compacted := make(map[uint32]uint32, len(priorities))
+	for i, priority := range priorities {
+		compacted[priority] = uint32(i)
+	}
+	for _, locality := range localities {
+		locality.Priority = compacted[locality.Priority]
+	}
+}
@@ -0,0 +1,109 @@
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyendpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestNewLocalityLbEndpoints(t *testing.T) {
+	leaves := []Leaf{
+		{Cluster: "cluster-c", Region: "us-east-1", Zone: "us-east-1a", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.3"}, {Hostname: "c.example.com"}}},
+		{Cluster: "cluster-a", Region: "eu-west-1", Zone: "eu-west-1a", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}},
+		{Cluster: "cluster-b", Region: "eu-west-1", Zone: "eu-west-1b", LoadBalancer: []v1.LoadBalancerIngress{{IP: "fd00::2"}}},
+		{Cluster: "cluster-d", Region: "eu-west-1", Zone: "eu-west-1a"},
+	}
+
+	for _, mode := range []string{LoadBalancingRoundRobin, LoadBalancingLocalityWeighted, LoadBalancingFailover} {
+		localities := newTestTranslator().newLocalityLbEndpoints(leaves, mode, BackendProtocolHTTP)
+
+		// The leaves are sorted by cluster, and the ones without reachable address are skipped.
+		expected := []*envoycorev3.Locality{
+			{Region: "eu-west-1", Zone: "eu-west-1a", SubZone: "cluster-a"},
+			{Region: "us-east-1", Zone: "us-east-1a", SubZone: "cluster-c"},
+		}
+		if len(localities) != len(expected) {
+			t.Fatalf("%s: expected %d localities, got %d", mode, len(expected), len(localities))
+		}
+		for i, locality := range localities {
+			if locality.Locality.Region != expected[i].Region || locality.Locality.Zone != expected[i].Zone || locality.Locality.SubZone != expected[i].SubZone {
+				t.Errorf("%s: expected the locality %v, got %v", mode, expected[i], locality.Locality)
+			}
+			if locality.Priority != 0 {
+				t.Errorf("%s: expected the priorities to be left to the fleets, got %d for %s", mode, locality.Priority, locality.Locality.SubZone)
+			}
+			if weighted := locality.LoadBalancingWeight != nil; weighted != (mode == LoadBalancingLocalityWeighted) {
+				t.Errorf("%s: unexpected weight %v for %s", mode, locality.LoadBalancingWeight, locality.Locality.SubZone)
+			}
+		}
+		if len(localities[1].LbEndpoints) != 2 {
+			t.Errorf("%s: expected an endpoint per address of cluster-c, got %d", mode, len(localities[1].LbEndpoints))
+		}
+	}
+}
+
+func TestLoadBalancingMode(t *testing.T) {
+	for _, test := range []struct {
+		annotation *string
+		mode       string
+		err        bool
+	}{
+		{annotation: nil, mode: LoadBalancingRoundRobin},
+		{annotation: pointer.StringPtr(LoadBalancingLocalityWeighted), mode: LoadBalancingLocalityWeighted},
+		{annotation: pointer.StringPtr(LoadBalancingFailover), mode: LoadBalancingFailover},
+		{annotation: pointer.StringPtr("random"), mode: LoadBalancingRoundRobin, err: true},
+	} {
+		ingress := newTestIngress("mode", "foo.com")
+		if test.annotation != nil {
+			ingress.Annotations[LoadBalancingAnnotation] = *test.annotation
+		}
+		mode, err := loadBalancingMode(ingress)
+		if mode != test.mode || (err != nil) != test.err {
+			t.Errorf("annotation %v: expected mode %q and error %t, got %q and %v", test.annotation, test.mode, test.err, mode, err)
+		}
+	}
+}
+
+func TestSetFailoverPriorities(t *testing.T) {
+	localities := []*envoycorev3.Locality{
+		{Region: "eu-west-1", Zone: "eu-west-1a", SubZone: "cluster-a"},
+		{Region: "eu-west-1", Zone: "eu-west-1b", SubZone: "cluster-b"},
+		{Region: "us-east-1", Zone: "us-east-1a", SubZone: "cluster-c"},
+		{SubZone: "cluster-d"},
+	}
+
+	for _, test := range []struct {
+		fleet      Fleet
+		priorities []uint32
+	}{
+		// The same zone comes first, then the same region, then the other regions.
+		{fleet: Fleet{Name: "edge-eu-a", Region: "eu-west-1", Zone: "eu-west-1a"}, priorities: []uint32{0, 1, 2, 2}},
+		{fleet: Fleet{Name: "edge-eu-b", Region: "eu-west-1", Zone: "eu-west-1b"}, priorities: []uint32{1, 0, 2, 2}},
+		// Without zone, the whole region comes first.
+		{fleet: Fleet{Name: "edge-eu", Region: "eu-west-1"}, priorities: []uint32{0, 0, 1, 1}},
+		// The priorities are contiguous, even without cluster in the zone of the fleet.
+		{fleet: Fleet{Name: "edge-us-b", Region: "us-east-1", Zone: "us-east-1b"}, priorities: []uint32{1, 1, 0, 1}},
+		// Without cluster in the region of the fleet, or without region, they all come first.
+		{fleet: Fleet{Name: "edge-ap", Region: "ap-south-1", Zone: "ap-south-1a"}, priorities: []uint32{0, 0, 0, 0}},
+		{fleet: Fleet{Name: "kcp-ingress"}, priorities: []uint32{0, 0, 0, 0}},
+	} {
+		endpoints := make([]*envoyendpointv3.LocalityLbEndpoints, 0, len(localities))
+		for _, locality := range localities {
+			// Stale priorities of another fleet are replaced.
+			endpoints = append(endpoints, &envoyendpointv3.LocalityLbEndpoints{Locality: locality, Priority: 5})
+		}
+		setFailoverPriorities(endpoints, test.fleet)
+
+		priorities := make([]uint32, 0, len(endpoints))
+		for _, locality := range endpoints {
+			priorities = append(priorities, locality.Priority)
+		}
+		if !reflect.DeepEqual(priorities, test.priorities) {
+			t.Errorf("fleet %s: expected the priorities %v, got %v", test.fleet.Name, test.priorities, priorities)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package envoy
+
+import (
+	"fmt"
+	"time"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// OutlierDetectionConfig configures the ejection of misbehaving cluster gateways.
+type OutlierDetectionConfig struct {
+	// Consecutive5xx is the number of consecutive 5xx responses to eject a gateway, 0 disables outlier detection.
+	Consecutive5xx uint32
+	// Interval between ejection analysis sweeps.
+	Interval time.Duration
+	// BaseEjectionTime is how long a gateway is ejected, multiplied by the number of times it has been ejected.
+	BaseEjectionTime time.Duration
+	// MaxEjectionPercent is the maximum percentage of gateways that can be ejected, from 0 to 100.
+	MaxEjectionPercent uint32
+}
+
+// Validate returns an error if the configuration can't be translated.
+func (c OutlierDetectionConfig) Validate() error {
+	if c.Consecutive5xx == 0 {
+		return nil
+	}
+	if c.Interval <= 0 || c.BaseEjectionTime <= 0 {
+		return fmt.Errorf("outlier detection interval and base ejection time must be positive")
+	}
+	if c.MaxEjectionPercent > 100 {
+		return fmt.Errorf("outlier detection max ejection percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// CircuitBreakersConfig limits the load sent by each Envoy cluster, to all the cluster gateways of an Ingress, or of one
+// of its traffic split clusters.
+type CircuitBreakersConfig struct {
+	MaxConnections     uint32
+	MaxPendingRequests uint32
+	MaxRequests        uint32
+}
+
+// Validate returns an error if the configuration can't be translated.
+func (c CircuitBreakersConfig) Validate() error {
+	if c.MaxConnections == 0 || c.MaxPendingRequests == 0 || c.MaxRequests == 0 {
+		return fmt.Errorf("circuit breaker thresholds must be positive")
+	}
+	return nil
+}
+
+// outlierDetectionConfig returns the outlier detection configuration of the Ingress: the controller configuration
+// overridden by the annotations of the Ingress.
+func (t *translator) outlierDetectionConfig(ingress networkingv1.Ingress) (OutlierDetectionConfig, error) {
+	config := t.outlierDetection
+	for _, err := range []error{
+		parseUint32Annotation(ingress.Annotations, OutlierConsecutive5xxAnnotation, &config.Consecutive5xx),
+		parseDurationAnnotation(ingress.Annotations, OutlierIntervalAnnotation, &config.Interval),
+		parseDurationAnnotation(ingress.Annotations, OutlierBaseEjectionTimeAnnotation, &config.BaseEjectionTime),
+		parseUint32Annotation(ingress.Annotations, OutlierMaxEjectionPercentAnnotation, &config.MaxEjectionPercent),
+	} {
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, config.Validate()
+}
+
+// circuitBreakersConfig returns the circuit breakers configuration of the Ingress: the controller configuration
+// overridden by the annotations of the Ingress.
+func (t *translator) circuitBreakersConfig(ingress networkingv1.Ingress) (CircuitBreakersConfig, error) {
+	config := t.circuitBreakers
+	for _, err := range []error{
+		parseUint32Annotation(ingress.Annotations, MaxConnectionsAnnotation, &config.MaxConnections),
+		parseUint32Annotation(ingress.Annotations, MaxPendingRequestsAnnotation, &config.MaxPendingRequests),
+		parseUint32Annotation(ingress.Annotations, MaxRequestsAnnotation, &config.MaxRequests),
+	} {
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, config.Validate()
+}
+
+// newOutlierDetection translates the outlier detection configuration, it returns nil if outlier detection is disabled.
+func newOutlierDetection(config OutlierDetectionConfig) *envoyclusterv3.OutlierDetection {
+	if config.Consecutive5xx == 0 {
+		return nil
+	}
+	return &envoyclusterv3.OutlierDetection{
+		Consecutive_5Xx:    wrapperspb.UInt32(config.Consecutive5xx),
+		Interval:           durationpb.New(config.Interval),
+		BaseEjectionTime:   durationpb.New(config.BaseEjectionTime),
+		MaxEjectionPercent: wrapperspb.UInt32(config.MaxEjectionPercent),
+	}
+}
+
+func newCircuitBreakers(config CircuitBreakersConfig) *envoyclusterv3.CircuitBreakers {
+	return &envoyclusterv3.CircuitBreakers{
+		Thresholds: []*envoyclusterv3.CircuitBreakers_Thresholds{{
+			MaxConnections:     wrapperspb.UInt32(config.MaxConnections),
+			MaxPendingRequests: wrapperspb.UInt32(config.MaxPendingRequests),
+			MaxRequests:        wrapperspb.UInt32(config.MaxRequests),
+		}},
+	}
+}
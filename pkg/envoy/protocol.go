@@ -0,0 +1,145 @@
+package envoy
+
+import (
+	"fmt"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyupstreamhttpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// httpProtocolOptionsName is the extension name of the upstream HTTP protocol options of a cluster.
+const httpProtocolOptionsName = "envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+
+// appProtocols maps the appProtocol of Service ports to backend protocols.
+var appProtocols = map[string]string{
+	"http":              BackendProtocolHTTP,
+	"h2c":               BackendProtocolH2C,
+	"kubernetes.io/h2c": BackendProtocolH2C,
+	"h2":                BackendProtocolH2,
+	"grpc":              BackendProtocolGRPC,
+}
+
+// backendProtocol returns the protocol spoken to the cluster gateways for the Ingress, from its
+// BackendProtocolAnnotation, or else from the appProtocol of the backend Service ports. All the backends of an Ingress
+// share its Envoy cluster, so they must agree on the protocol.
+func backendProtocol(ingress networkingv1.Ingress, services []v1.Service) (string, error) {
+	if protocol, ok := ingress.Annotations[BackendProtocolAnnotation]; ok {
+		switch protocol {
+		case BackendProtocolHTTP, BackendProtocolH2C, BackendProtocolH2, BackendProtocolGRPC:
+			return protocol, nil
+		}
+		return BackendProtocolHTTP, fmt.Errorf("unknown backend protocol %q", protocol)
+	}
+
+	backends := make([]*networkingv1.IngressServiceBackend, 0)
+	if ingress.Spec.DefaultBackend != nil && ingress.Spec.DefaultBackend.Service != nil {
+		backends = append(backends, ingress.Spec.DefaultBackend.Service)
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil {
+				backends = append(backends, path.Backend.Service)
+			}
+		}
+	}
+
+	protocol := ""
+	for _, backend := range backends {
+		p := appProtocol(backend, services)
+		if protocol != "" && p != protocol {
+			return BackendProtocolHTTP, fmt.Errorf("backends use different protocols, %q and %q", protocol, p)
+		}
+		protocol = p
+	}
+	if protocol == "" {
+		return BackendProtocolHTTP, nil
+	}
+	return protocol, nil
+}
+
+// appProtocol returns the protocol of the Service port of the backend, from its appProtocol.
+// Ports without a known appProtocol are HTTP/1.1.
+func appProtocol(backend *networkingv1.IngressServiceBackend, services []v1.Service) string {
+	for _, service := range services {
+		if service.Name != backend.Name {
+			continue
+		}
+		for _, port := range service.Spec.Ports {
+			// Backends reference their Service port either by name or by number.
+			if backend.Port.Name != "" {
+				if port.Name != backend.Port.Name {
+					continue
+				}
+			} else if port.Port != backend.Port.Number {
+				continue
+			}
+			if port.AppProtocol != nil {
+				if protocol, ok := appProtocols[*port.AppProtocol]; ok {
+					return protocol
+				}
+			}
+		}
+	}
+	return BackendProtocolHTTP
+}
+
+// isHTTP2 returns whether the backend protocol is spoken over HTTP/2.
+func isHTTP2(protocol string) bool {
+	return protocol != BackendProtocolHTTP
+}
+
+// setUpstreamProtocol sets the HTTP version spoken to the cluster gateways, and originates TLS to the ones that need
+// it. The SNI is the host of each request, falling back to the given one for health checks, and the certificates of the
+// gateways must be valid for the SNI of the requests unless verification is disabled.
+func (t *translator) setUpstreamProtocol(cluster *envoyclusterv3.Cluster, protocol string, sni string) error {
+	tls := hasUpstreamTLS(cluster)
+	if !tls && !isHTTP2(protocol) {
+		// Plain HTTP/1.1 is the Envoy default.
+		return nil
+	}
+
+	alpn := []string{"http/1.1"}
+	options := &envoyupstreamhttpv3.HttpProtocolOptions{
+		UpstreamProtocolOptions: &envoyupstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig_{
+			ExplicitHttpConfig: &envoyupstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig{
+				ProtocolConfig: &envoyupstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig_HttpProtocolOptions{
+					HttpProtocolOptions: &envoycorev3.Http1ProtocolOptions{},
+				},
+			},
+		},
+	}
+	if isHTTP2(protocol) {
+		alpn = []string{"h2"}
+		options.UpstreamProtocolOptions = &envoyupstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig_{
+			ExplicitHttpConfig: &envoyupstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig{
+				ProtocolConfig: &envoyupstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+					Http2ProtocolOptions: &envoycorev3.Http2ProtocolOptions{},
+				},
+			},
+		}
+	}
+
+	if tls {
+		if err := t.setUpstreamTLS(cluster, sni, alpn); err != nil {
+			return err
+		}
+		options.UpstreamHttpProtocolOptions = &envoycorev3.UpstreamHttpProtocolOptions{
+			AutoSni:           true,
+			AutoSanValidation: !t.upstreamTLS.InsecureSkipVerify,
+		}
+	}
+
+	optionsAny, err := marshalAny(options)
+	if err != nil {
+		return err
+	}
+	cluster.TypedExtensionProtocolOptions = map[string]*anypb.Any{httpProtocolOptionsName: optionsAny}
+	return nil
+}
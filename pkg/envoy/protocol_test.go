@@ -0,0 +1,40 @@
+package envoy
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAppProtocol(t *testing.T) {
+	grpc, h2c := "grpc", "kubernetes.io/h2c"
+	services := []v1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend"},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{
+			{Name: "grpc", Port: 9000, AppProtocol: &grpc},
+			{Name: "h2c", Port: 8080, AppProtocol: &h2c},
+			{Name: "http", Port: 80},
+		}},
+	}}
+
+	for _, tc := range []struct {
+		name     string
+		port     networkingv1.ServiceBackendPort
+		expected string
+	}{
+		{"named port", networkingv1.ServiceBackendPort{Name: "grpc"}, BackendProtocolGRPC},
+		{"port number of a named port", networkingv1.ServiceBackendPort{Number: 9000}, BackendProtocolGRPC},
+		{"port number", networkingv1.ServiceBackendPort{Number: 8080}, BackendProtocolH2C},
+		{"port without appProtocol", networkingv1.ServiceBackendPort{Number: 80}, BackendProtocolHTTP},
+		{"unknown port", networkingv1.ServiceBackendPort{Number: 1234}, BackendProtocolHTTP},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := &networkingv1.IngressServiceBackend{Name: "backend", Port: tc.port}
+			if protocol := appProtocol(backend, services); protocol != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, protocol)
+			}
+		})
+	}
+}
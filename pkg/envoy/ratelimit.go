@@ -0,0 +1,121 @@
+package envoy
+
+import (
+	"fmt"
+	"time"
+
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoylocalratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	envoyfilterhcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoytypev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+const (
+	// RateLimitScopeRoute gives each route of the Ingress its own token bucket.
+	RateLimitScopeRoute = "route"
+	// RateLimitScopeHost shares a token bucket between all the routes of each host of the Ingress.
+	RateLimitScopeHost = "host"
+
+	localRateLimitFilter     = "envoy.filters.http.local_ratelimit"
+	localRateLimitStatPrefix = "http_local_rate_limiter"
+)
+
+// rateLimitConfig is the local rate limit of an Ingress, a token bucket getting Requests tokens every Interval, up to
+// Burst tokens.
+type rateLimitConfig struct {
+	Requests uint32
+	Interval time.Duration
+	Burst    uint32
+	Scope    string
+	Status   uint32
+}
+
+// Validate returns an error if the configuration can't be translated.
+func (c rateLimitConfig) Validate() error {
+	if c.Requests == 0 {
+		return fmt.Errorf("%s must be positive", RateLimitRequestsAnnotation)
+	}
+	if c.Interval < time.Millisecond {
+		return fmt.Errorf("%s must be at least 1ms", RateLimitIntervalAnnotation)
+	}
+	if c.Burst < c.Requests {
+		return fmt.Errorf("%s can't be lower than %s", RateLimitBurstAnnotation, RateLimitRequestsAnnotation)
+	}
+	if c.Scope != RateLimitScopeRoute && c.Scope != RateLimitScopeHost {
+		return fmt.Errorf("unknown %s %q", RateLimitScopeAnnotation, c.Scope)
+	}
+	if _, ok := envoytypev3.StatusCode_name[int32(c.Status)]; !ok || c.Status < 400 {
+		return fmt.Errorf("invalid %s %d", RateLimitStatusAnnotation, c.Status)
+	}
+	return nil
+}
+
+// rateLimitConfig returns the local rate limit of the Ingress from its annotations, or nil if it isn't rate limited.
+func (t *translator) rateLimitConfig(ingress networkingv1.Ingress) (*rateLimitConfig, error) {
+	if _, ok := ingress.Annotations[RateLimitRequestsAnnotation]; !ok {
+		return nil, nil
+	}
+
+	config := rateLimitConfig{Interval: time.Second, Scope: RateLimitScopeRoute, Status: t.rateLimitStatus}
+	if scope, ok := ingress.Annotations[RateLimitScopeAnnotation]; ok {
+		config.Scope = scope
+	}
+	for _, err := range []error{
+		parseUint32Annotation(ingress.Annotations, RateLimitRequestsAnnotation, &config.Requests),
+		parseDurationAnnotation(ingress.Annotations, RateLimitIntervalAnnotation, &config.Interval),
+		parseUint32Annotation(ingress.Annotations, RateLimitStatusAnnotation, &config.Status),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	config.Burst = config.Requests
+	if err := parseUint32Annotation(ingress.Annotations, RateLimitBurstAnnotation, &config.Burst); err != nil {
+		return nil, err
+	}
+	return &config, config.Validate()
+}
+
+// newLocalRateLimit returns the per-route configuration of the local rate limit filter, enforcing the rate limit.
+func newLocalRateLimit(config rateLimitConfig) (*anypb.Any, error) {
+	always := &envoycorev3.RuntimeFractionalPercent{
+		DefaultValue: &envoytypev3.FractionalPercent{Numerator: 100, Denominator: envoytypev3.FractionalPercent_HUNDRED},
+	}
+	return marshalAny(&envoylocalratelimitv3.LocalRateLimit{
+		StatPrefix: localRateLimitStatPrefix,
+		Status:     &envoytypev3.HttpStatus{Code: envoytypev3.StatusCode(config.Status)},
+		TokenBucket: &envoytypev3.TokenBucket{
+			MaxTokens:     config.Burst,
+			TokensPerFill: wrapperspb.UInt32(config.Requests),
+			FillInterval:  durationpb.New(config.Interval),
+		},
+		FilterEnabled:  always,
+		FilterEnforced: always,
+	})
+}
+
+// setRouteRateLimit rate limits the route with its own token bucket.
+func setRouteRateLimit(route *envoyroutev3.Route, rateLimit *anypb.Any) {
+	if route.TypedPerFilterConfig == nil {
+		route.TypedPerFilterConfig = map[string]*anypb.Any{}
+	}
+	route.TypedPerFilterConfig[localRateLimitFilter] = rateLimit
+}
+
+// newLocalRateLimitFilter returns the local rate limit filter of the HTTP connection managers. It doesn't limit anything
+// by itself, the rate limits are set on the routes and virtual hosts.
+func newLocalRateLimitFilter() (*envoyfilterhcmv3.HttpFilter, error) {
+	configAny, err := marshalAny(&envoylocalratelimitv3.LocalRateLimit{StatPrefix: localRateLimitStatPrefix})
+	if err != nil {
+		return nil, err
+	}
+	return &envoyfilterhcmv3.HttpFilter{
+		Name:       localRateLimitFilter,
+		ConfigType: &envoyfilterhcmv3.HttpFilter_TypedConfig{TypedConfig: configAny},
+	}, nil
+}
@@ -0,0 +1,163 @@
+package envoy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// redirectCodes are the redirect statuses of RedirectCodeAnnotation.
+var redirectCodes = map[string]envoyroutev3.RedirectAction_RedirectResponseCode{
+	"301": envoyroutev3.RedirectAction_MOVED_PERMANENTLY,
+	"302": envoyroutev3.RedirectAction_FOUND,
+	"307": envoyroutev3.RedirectAction_TEMPORARY_REDIRECT,
+	"308": envoyroutev3.RedirectAction_PERMANENT_REDIRECT,
+}
+
+// redirectConfig is the redirect of the routes of an Ingress, to another host, another path, or both.
+type redirectConfig struct {
+	host string
+	port uint32
+	// path replaces the matched path of the requests, like a rewrite target.
+	path string
+	code envoyroutev3.RedirectAction_RedirectResponseCode
+}
+
+// ingressRedirect returns the redirect of the routes of the Ingress from its annotations, or nil if its requests are
+// forwarded to its clusters.
+func ingressRedirect(ingress networkingv1.Ingress) (*redirectConfig, error) {
+	host, hasHost := ingress.Annotations[RedirectHostAnnotation]
+	path, hasPath := ingress.Annotations[RedirectPathAnnotation]
+	code, hasCode := ingress.Annotations[RedirectCodeAnnotation]
+	if !hasHost && !hasPath {
+		if hasCode {
+			return nil, fmt.Errorf("%s is set without %s or %s", RedirectCodeAnnotation, RedirectHostAnnotation, RedirectPathAnnotation)
+		}
+		return nil, nil
+	}
+
+	config := &redirectConfig{path: path, code: envoyroutev3.RedirectAction_MOVED_PERMANENTLY}
+	if hasHost {
+		var err error
+		if config.host, config.port, err = splitHostPort(host); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", RedirectHostAnnotation, err)
+		}
+	}
+	if hasPath && !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("%s must start with /", RedirectPathAnnotation)
+	}
+	if hasCode {
+		var ok bool
+		if config.code, ok = redirectCodes[code]; !ok {
+			return nil, fmt.Errorf("unsupported %s %q, expected 301, 302, 307 or 308", RedirectCodeAnnotation, code)
+		}
+	}
+	return config, nil
+}
+
+// newRedirect returns the redirect of the requests matching the Ingress path.
+func (t *translator) newRedirect(path networkingv1.HTTPIngressPath, config redirectConfig) (*envoyroutev3.RedirectAction, error) {
+	redirect := &envoyroutev3.RedirectAction{
+		HostRedirect: config.host,
+		PortRedirect: config.port,
+		ResponseCode: config.code,
+	}
+	if config.path == "" {
+		return redirect, nil
+	}
+
+	prefix, regex, err := t.pathRewrite(path, config.path)
+	if err != nil {
+		return nil, err
+	}
+	if regex != nil {
+		redirect.PathRewriteSpecifier = &envoyroutev3.RedirectAction_RegexRewrite{RegexRewrite: regex}
+	} else {
+		redirect.PathRewriteSpecifier = &envoyroutev3.RedirectAction_PrefixRewrite{PrefixRewrite: prefix}
+	}
+	return redirect, nil
+}
+
+// httpsRedirectEnabled returns false if the Ingress disables the redirects to HTTPS with HTTPSRedirectAnnotation.
+func httpsRedirectEnabled(ingress networkingv1.Ingress) (bool, error) {
+	value, ok := ingress.Annotations[HTTPSRedirectAnnotation]
+	if !ok {
+		return true, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return true, fmt.Errorf("invalid %s: %v", HTTPSRedirectAnnotation, err)
+	}
+	return enabled, nil
+}
+
+// ingressTLSCovers returns true if the Ingress has a TLS entry for the host.
+func ingressTLSCovers(ingress networkingv1.Ingress, host string) bool {
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName != "" && tlsCovers(tls.Hosts, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsCovers returns true if the certificate of the TLS hosts is served for the host. Certificates without hosts are
+// served for any host.
+func tlsCovers(tlsHosts []string, host string) bool {
+	if len(tlsHosts) == 0 {
+		return true
+	}
+	for _, tlsHost := range tlsHosts {
+		if tlsHost == host {
+			return true
+		}
+		// Wildcards match a single DNS label.
+		if strings.HasPrefix(tlsHost, "*.") && host != catchAllHost {
+			if i := strings.Index(host, "."); i > 0 && host[i:] == tlsHost[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newHTTPSRedirectRoutes returns the routes of the plaintext listener, where the routes asking for it are replaced by
+// redirects to HTTPS, as long as the TLS listener serves a certificate for their host. Routes already redirecting are
+// kept, so their requests are redirected only once.
+func (t *translator) newHTTPSRedirectRoutes(routes []hostRoute, chains []tlsFilterChain) []hostRoute {
+	redirect := &envoyroutev3.RedirectAction{
+		SchemeRewriteSpecifier: &envoyroutev3.RedirectAction_HttpsRedirect{HttpsRedirect: true},
+		// Keeps the method and body of the requests.
+		ResponseCode: envoyroutev3.RedirectAction_PERMANENT_REDIRECT,
+	}
+	if *t.envoyTLSListenPort != 443 {
+		redirect.PortRedirect = uint32(*t.envoyTLSListenPort)
+	}
+
+	httpRoutes := make([]hostRoute, 0, len(routes))
+	for _, r := range routes {
+		if r.httpsRedirect && r.route.GetRoute() != nil && chainsCover(chains, r.host) {
+			r.route = &envoyroutev3.Route{
+				Name:     r.route.Name,
+				Match:    r.route.Match,
+				Metadata: r.route.Metadata,
+				Action:   &envoyroutev3.Route_Redirect{Redirect: redirect},
+			}
+		}
+		httpRoutes = append(httpRoutes, r)
+	}
+	return httpRoutes
+}
+
+// chainsCover returns true if one of the TLS filter chains serves a certificate for the host.
+func chainsCover(chains []tlsFilterChain, host string) bool {
+	for _, chain := range chains {
+		if tlsCovers(chain.hosts, host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package envoy
+
+import (
+	"testing"
+
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+)
+
+func TestNewHTTPSRedirectRoutes(t *testing.T) {
+	newRoutes := func() []hostRoute {
+		tr := &translator{}
+		return []hostRoute{
+			{host: "foo.com", route: tr.newRoute("foo", newRegexRouteMatch("/foo"), "cluster", nil), httpsRedirect: true},
+			{host: "bar.com", route: tr.newRoute("bar", newRegexRouteMatch("/bar"), "cluster", nil), httpsRedirect: true},
+			{host: "baz.com", route: tr.newRoute("baz", newRegexRouteMatch("/baz"), "cluster", nil), httpsRedirect: false},
+			{host: "foo.com", route: &envoyroutev3.Route{
+				Name:   "redirect",
+				Action: &envoyroutev3.Route_Redirect{Redirect: &envoyroutev3.RedirectAction{HostRedirect: "qux.com"}},
+			}, httpsRedirect: true},
+		}
+	}
+	chains := []tlsFilterChain{{hosts: []string{"foo.com", "baz.com"}, secretName: "secret"}}
+
+	for _, tlsPort := range []uint{443, 8443} {
+		tr := &translator{}
+		tr.envoyTLSListenPort = &tlsPort
+		routes := newRoutes()
+
+		httpRoutes := tr.newHTTPSRedirectRoutes(routes, chains)
+
+		// Only the routes asking for it, with a certificate for their host, are redirected to HTTPS.
+		redirect := httpRoutes[0].route.GetRedirect()
+		if redirect == nil || !redirect.GetHttpsRedirect() {
+			t.Fatalf("expected the route of a host with a certificate to be redirected to HTTPS, got %v", httpRoutes[0].route)
+		}
+		expectedPort := uint32(0)
+		if tlsPort != 443 {
+			expectedPort = uint32(tlsPort)
+		}
+		if redirect.PortRedirect != expectedPort {
+			t.Errorf("expected the redirect port %d with the TLS listener on port %d, got %d", expectedPort, tlsPort, redirect.PortRedirect)
+		}
+		if redirect.ResponseCode != envoyroutev3.RedirectAction_PERMANENT_REDIRECT {
+			t.Errorf("expected a permanent redirect keeping the method, got %s", redirect.ResponseCode)
+		}
+		if httpRoutes[0].route.Match != routes[0].route.Match || routes[0].route.GetRoute() == nil {
+			t.Errorf("expected the redirect to keep the match of the route, and the TLS route to be untouched")
+		}
+		for i := 1; i < len(routes); i++ {
+			if httpRoutes[i].route != routes[i].route {
+				t.Errorf("expected route %q not to be redirected to HTTPS", routes[i].route.Name)
+			}
+		}
+	}
+}
+
+func TestTLSCovers(t *testing.T) {
+	for _, test := range []struct {
+		tlsHosts []string
+		host     string
+		covers   bool
+	}{
+		{tlsHosts: nil, host: "foo.com", covers: true},
+		{tlsHosts: []string{"foo.com"}, host: "foo.com", covers: true},
+		{tlsHosts: []string{"foo.com"}, host: "bar.com", covers: false},
+		{tlsHosts: []string{"*.foo.com"}, host: "bar.foo.com", covers: true},
+		{tlsHosts: []string{"*.foo.com"}, host: "baz.bar.foo.com", covers: false},
+		{tlsHosts: []string{"*.foo.com"}, host: "foo.com", covers: false},
+		{tlsHosts: []string{"*.foo.com"}, host: "*.foo.com", covers: true},
+		{tlsHosts: []string{"*.foo.com"}, host: catchAllHost, covers: false},
+	} {
+		if covers := tlsCovers(test.tlsHosts, test.host); covers != test.covers {
+			t.Errorf("expected TLS hosts %v covering %q to be %t", test.tlsHosts, test.host, test.covers)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package envoy
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoymatcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// HostRewriteUpstream rewrites the host of the requests to the hostname of the cluster gateway they are sent to.
+const HostRewriteUpstream = "upstream"
+
+// regexGroupReference matches the references to capture groups in a RE2 substitution, like "\1".
+var regexGroupReference = regexp.MustCompile(`\\(\d)`)
+
+// headerOptions are the headers added to and removed from the requests and responses of the routes of an Ingress.
+type headerOptions struct {
+	requestHeadersToAdd     []*envoycorev3.HeaderValueOption
+	requestHeadersToRemove  []string
+	responseHeadersToAdd    []*envoycorev3.HeaderValueOption
+	responseHeadersToRemove []string
+}
+
+// ingressHeaderOptions returns the header options of the Ingress from its annotations.
+func ingressHeaderOptions(ingress networkingv1.Ingress) (headerOptions, error) {
+	var options headerOptions
+	var err error
+	if options.requestHeadersToAdd, err = parseHeadersToAdd(ingress, RequestHeadersAddAnnotation, true); err != nil {
+		return headerOptions{}, err
+	}
+	if options.requestHeadersToRemove, err = parseHeadersToRemove(ingress, RequestHeadersRemoveAnnotation, true); err != nil {
+		return headerOptions{}, err
+	}
+	if options.responseHeadersToAdd, err = parseHeadersToAdd(ingress, ResponseHeadersAddAnnotation, false); err != nil {
+		return headerOptions{}, err
+	}
+	if options.responseHeadersToRemove, err = parseHeadersToRemove(ingress, ResponseHeadersRemoveAnnotation, false); err != nil {
+		return headerOptions{}, err
+	}
+	return options, nil
+}
+
+// parseHeadersToAdd parses a comma separated list of name=value pairs. The headers replace the existing ones with the
+// same name.
+func parseHeadersToAdd(ingress networkingv1.Ingress, annotation string, request bool) ([]*envoycorev3.HeaderValueOption, error) {
+	headers := make([]*envoycorev3.HeaderValueOption, 0)
+	for _, pair := range splitList(ingress.Annotations[annotation]) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid %s entry %q, expected name=value", annotation, pair)
+		}
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
+		if err := validateHeaderName(name, request); err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %v", annotation, pair, err)
+		}
+		headers = append(headers, &envoycorev3.HeaderValueOption{
+			Header: &envoycorev3.HeaderValue{Key: name, Value: strings.TrimSpace(kv[1])},
+			Append: wrapperspb.Bool(false),
+		})
+	}
+	return headers, nil
+}
+
+// parseHeadersToRemove parses a comma separated list of header names.
+func parseHeadersToRemove(ingress networkingv1.Ingress, annotation string, request bool) ([]string, error) {
+	names := splitList(strings.ToLower(ingress.Annotations[annotation]))
+	for _, name := range names {
+		if err := validateHeaderName(name, request); err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %v", annotation, name, err)
+		}
+	}
+	return names, nil
+}
+
+// validateHeaderName returns an error if the header can't be modified by Envoy. The host of the requests is changed
+// with HostRewriteAnnotation instead.
+func validateHeaderName(name string, request bool) error {
+	if !httpToken.MatchString(name) {
+		return fmt.Errorf("invalid header name")
+	}
+	if request && name == "host" {
+		return fmt.Errorf("the host is rewritten with %s", HostRewriteAnnotation)
+	}
+	return nil
+}
+
+// setRouteHeaders adds and removes the headers of the requests and responses of the route.
+func setRouteHeaders(route *envoyroutev3.Route, options headerOptions) {
+	route.RequestHeadersToAdd = append(route.RequestHeadersToAdd, options.requestHeadersToAdd...)
+	route.RequestHeadersToRemove = append(route.RequestHeadersToRemove, options.requestHeadersToRemove...)
+	route.ResponseHeadersToAdd = append(route.ResponseHeadersToAdd, options.responseHeadersToAdd...)
+	route.ResponseHeadersToRemove = append(route.ResponseHeadersToRemove, options.responseHeadersToRemove...)
+}
+
+// hostRewrite returns the host rewrite of the routes of the Ingress, either HostRewriteUpstream or a literal host, or
+// an empty string if the host is forwarded untouched.
+func hostRewrite(ingress networkingv1.Ingress) (string, error) {
+	value := ingress.Annotations[HostRewriteAnnotation]
+	if value == "" || value == HostRewriteUpstream {
+		return value, nil
+	}
+
+	if _, _, err := splitHostPort(value); err != nil {
+		return "", fmt.Errorf("invalid %s: %v", HostRewriteAnnotation, err)
+	}
+	return value, nil
+}
+
+// splitHostPort splits a host with an optional port, 0 if there is none, and checks they are valid.
+func splitHostPort(value string) (string, uint32, error) {
+	host, port := value, uint64(0)
+	if h, p, err := net.SplitHostPort(value); err == nil {
+		if port, err = strconv.ParseUint(p, 10, 16); err != nil || port == 0 {
+			return "", 0, fmt.Errorf("invalid port %q", p)
+		}
+		host = h
+	}
+	if errs := validation.IsDNS1123Subdomain(host); len(errs) > 0 && net.ParseIP(host) == nil {
+		return "", 0, fmt.Errorf("invalid host %q: %s", host, strings.Join(errs, ", "))
+	}
+	return host, uint32(port), nil
+}
+
+// setHostRewrite rewrites the host of the requests of the route. Gateways reached by IP have no hostname, so
+// HostRewriteUpstream leaves the host of their requests untouched.
+func setHostRewrite(route *envoyroutev3.RouteAction, host string) {
+	if host == HostRewriteUpstream {
+		route.HostRewriteSpecifier = &envoyroutev3.RouteAction_AutoHostRewrite{AutoHostRewrite: wrapperspb.Bool(true)}
+	} else {
+		route.HostRewriteSpecifier = &envoyroutev3.RouteAction_HostRewriteLiteral{HostRewriteLiteral: host}
+	}
+}
+
+// pathRewrite returns how the path of the requests matching the Ingress path is replaced with the target, either as
+// an Envoy prefix rewrite or a regular expression substitution:
+//   - Exact paths are replaced by the target.
+//   - The matched prefix of Prefix paths is replaced by the target, so with the "/foo" path and the "/bar" target,
+//     "/foo" becomes "/bar" and "/foo/baz" becomes "/bar/baz".
+//   - ImplementationSpecific paths matched as Envoy prefixes have their prefix replaced by the target as is.
+//   - ImplementationSpecific paths matched as regular expressions are substituted with the target, which can reference
+//     their capture groups, like "/\1".
+func (t *translator) pathRewrite(path networkingv1.HTTPIngressPath, target string) (string, *envoymatcherv3.RegexMatchAndSubstitute, error) {
+	if !strings.HasPrefix(target, "/") {
+		return "", nil, fmt.Errorf("%q must start with /", target)
+	}
+
+	switch pathType(path) {
+	case networkingv1.PathTypeExact:
+		return "", newRegexRewrite("^.*$", escapeSubstitution(target)), nil
+
+	case networkingv1.PathTypePrefix:
+		prefix, target := regexp.QuoteMeta(strings.TrimRight(path.Path, "/")), strings.TrimRight(target, "/")
+		if target == "" {
+			// The remaining path, if any, replaces the prefix, which is rewritten to / on its own.
+			return "", newRegexRewrite("^"+prefix+"/?(.*)$", `/\1`), nil
+		}
+		return "", newRegexRewrite("^"+prefix+"(/.*)?$", escapeSubstitution(target)+`\1`), nil
+
+	case networkingv1.PathTypeImplementationSpecific:
+		if t.implementationSpecificPathType != PathTypeRegex {
+			return target, nil, nil
+		}
+		regex, err := regexp.Compile(path.Path)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, reference := range regexGroupReference.FindAllStringSubmatch(target, -1) {
+			if group, _ := strconv.Atoi(reference[1]); group > regex.NumSubexp() {
+				return "", nil, fmt.Errorf("%q references the missing capture group %d of path %q", target, group, path.Path)
+			}
+		}
+		// Routes match the full path, while the substitution replaces every match in it.
+		return "", newRegexRewrite("^(?:"+path.Path+")$", target), nil
+	}
+	return "", nil, fmt.Errorf("unknown path type %q", pathType(path))
+}
+
+// setPathRewrite rewrites the path of the requests of the route matching the Ingress path with the rewrite target.
+func (t *translator) setPathRewrite(route *envoyroutev3.RouteAction, path networkingv1.HTTPIngressPath, target string) error {
+	prefix, regex, err := t.pathRewrite(path, target)
+	if err != nil {
+		return err
+	}
+	route.PrefixRewrite, route.RegexRewrite = prefix, regex
+	return nil
+}
+
+// escapeSubstitution escapes the backslashes of a literal RE2 substitution.
+func escapeSubstitution(value string) string {
+	return strings.ReplaceAll(value, `\`, `\\`)
+}
+
+func newRegexRewrite(regex, substitution string) *envoymatcherv3.RegexMatchAndSubstitute {
+	return &envoymatcherv3.RegexMatchAndSubstitute{
+		Pattern: &envoymatcherv3.RegexMatcher{
+			EngineType: &envoymatcherv3.RegexMatcher_GoogleRe2{GoogleRe2: &envoymatcherv3.RegexMatcher_GoogleRE2{}},
+			Regex:      regex,
+		},
+		Substitution: substitution,
+	}
+}
@@ -0,0 +1,84 @@
+package envoy
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// rewritePath returns the path of a request once rewritten like Envoy does.
+func rewritePath(t *testing.T, tr *translator, path networkingv1.HTTPIngressPath, target, request string) string {
+	match, err := tr.newRouteMatch(path)
+	if err != nil {
+		t.Fatalf("unexpected error for path %q: %v", path.Path, err)
+	}
+	prefix, regex, err := tr.pathRewrite(path, target)
+	if err != nil {
+		t.Fatalf("unexpected error rewriting path %q to %q: %v", path.Path, target, err)
+	}
+	if regex != nil {
+		// RE2 substitutions reference the capture groups as \1, Go as ${1}.
+		substitution := regexGroupReference.ReplaceAllString(strings.ReplaceAll(regex.Substitution, `\\`, `\`), "$${$1}")
+		return regexp.MustCompile(regex.Pattern.Regex).ReplaceAllString(request, substitution)
+	}
+	return prefix + strings.TrimPrefix(request, match.GetPrefix())
+}
+
+func TestPathRewrite(t *testing.T) {
+	exact, prefix, implementationSpecific := networkingv1.PathTypeExact, networkingv1.PathTypePrefix, networkingv1.PathTypeImplementationSpecific
+
+	tests := []struct {
+		pathType           networkingv1.PathType
+		implementationType string
+		path               string
+		target             string
+		request            string
+		expected           string
+	}{
+		{pathType: exact, path: "/foo", target: "/bar", request: "/foo", expected: "/bar"},
+		{pathType: prefix, path: "/foo", target: "/bar", request: "/foo", expected: "/bar"},
+		{pathType: prefix, path: "/foo", target: "/bar", request: "/foo/baz", expected: "/bar/baz"},
+		{pathType: prefix, path: "/foo/", target: "/bar/", request: "/foo/baz", expected: "/bar/baz"},
+		{pathType: prefix, path: "/foo/", target: "/bar/", request: "/foo/", expected: "/bar/"},
+		{pathType: prefix, path: "/foo", target: "/", request: "/foo", expected: "/"},
+		{pathType: prefix, path: "/foo", target: "/", request: "/foo/baz", expected: "/baz"},
+		{pathType: prefix, path: "/", target: "/bar", request: "/baz", expected: "/bar/baz"},
+		{pathType: prefix, path: "/", target: "/", request: "/baz", expected: "/baz"},
+		{pathType: prefix, path: "/a.b", target: "/c", request: "/a.b/d", expected: "/c/d"},
+		{pathType: implementationSpecific, implementationType: PathTypePrefix, path: "/foo", target: "/bar", request: "/foobaz", expected: "/barbaz"},
+		{pathType: implementationSpecific, implementationType: PathTypeRegex, path: "/foo/([0-9]+)", target: `/bar/\1`, request: "/foo/12", expected: "/bar/12"},
+	}
+
+	for _, test := range tests {
+		tr := newTestTranslator()
+		if test.implementationType != "" {
+			tr.implementationSpecificPathType = test.implementationType
+		}
+		path := networkingv1.HTTPIngressPath{Path: test.path, PathType: &test.pathType}
+		if rewritten := rewritePath(t, tr, path, test.target, test.request); rewritten != test.expected {
+			t.Errorf("path %q of type %s rewritten to %q: expected %q for %q, got %q", test.path, test.pathType, test.target, test.expected, test.request, rewritten)
+		}
+	}
+}
+
+func TestPathRewriteErrors(t *testing.T) {
+	implementationSpecific := networkingv1.PathTypeImplementationSpecific
+	tr := newTestTranslator()
+	tr.implementationSpecificPathType = PathTypeRegex
+
+	for _, test := range []struct {
+		path   string
+		target string
+	}{
+		{path: "/foo", target: "bar"},
+		{path: "/foo/([0-9]+)", target: `/bar/\2`},
+		{path: "/foo(", target: "/bar"},
+	} {
+		path := networkingv1.HTTPIngressPath{Path: test.path, PathType: &implementationSpecific}
+		if _, _, err := tr.pathRewrite(path, test.target); err == nil {
+			t.Errorf("expected an error rewriting path %q to %q", test.path, test.target)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package envoy
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	discoveryservice "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	listenerservice "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+	routeservice "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	secretservice "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	xds "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const grpcMaxConcurrentStreams = 1000000
+
+// XdsServer serves the snapshots of the Envoy fleets to their Envoys.
+type XdsServer struct {
+	port          uint
+	server        xds.Server
+	snapshotCache cache.SnapshotCache
+}
+
+// NewXdsServer returns an xDS server listening on the port, where the node hash maps each Envoy to its fleet.
+func NewXdsServer(port uint, hash cache.NodeHash) *XdsServer {
+	snapshotCache := cache.NewSnapshotCache(true, hash, nil)
+	return &XdsServer{
+		port:          port,
+		server:        xds.NewServer(context.Background(), snapshotCache, nil),
+		snapshotCache: snapshotCache,
+	}
+}
+
+// RunManagementServer serves the xDS APIs until the listener fails.
+func (s *XdsServer) RunManagementServer() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	grpcServer := grpc.NewServer(grpc.MaxConcurrentStreams(grpcMaxConcurrentStreams))
+	discoveryservice.RegisterAggregatedDiscoveryServiceServer(grpcServer, s.server)
+	clusterservice.RegisterClusterDiscoveryServiceServer(grpcServer, s.server)
+	endpointservice.RegisterEndpointDiscoveryServiceServer(grpcServer, s.server)
+	listenerservice.RegisterListenerDiscoveryServiceServer(grpcServer, s.server)
+	routeservice.RegisterRouteDiscoveryServiceServer(grpcServer, s.server)
+	secretservice.RegisterSecretDiscoveryServiceServer(grpcServer, s.server)
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+
+	if err := grpcServer.Serve(listener); err != nil {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
+
+// SetSnapshot sets the snapshot sent to the Envoys of the fleet.
+func (s *XdsServer) SetSnapshot(fleet string, snapshot cache.Snapshot) error {
+	return s.snapshotCache.SetSnapshot(context.Background(), fleet, snapshot)
+}
@@ -0,0 +1,285 @@
+package envoy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"reflect"
+	"sort"
+
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	gocache "github.com/patrickmn/go-cache"
+	v1 "k8s.io/api/core/v1"
+)
+
+// fleetSnapshot holds what the last snapshot of a fleet was built from: the Ingresses it serves, and their virtual hosts
+// merged by host, along with their versions. Building the next snapshot only translates, merges and hashes again the
+// resources of the Ingresses updated in between.
+type fleetSnapshot struct {
+	fleet Fleet
+	// dirty holds the keys of the Ingresses updated or deleted since the last snapshot.
+	dirty map[string]struct{}
+	// ingresses holds the Ingresses served by the fleet by key, with their load assignments for the fleet.
+	ingresses map[string]cachedIngress
+	// hostIngresses holds the keys of the Ingresses with routes for each host.
+	hostIngresses map[string]map[string]struct{}
+	// chains are the TLS filter chains of the last snapshot, which decide the plaintext routes redirected to HTTPS.
+	chains []tlsFilterChain
+	// virtualHosts and httpsVirtualHosts are the virtual hosts of the plaintext and TLS listeners by host, and conflicts
+	// the host conflicts found when merging the routes of each host.
+	virtualHosts      map[string]versionedVirtualHost
+	httpsVirtualHosts map[string]versionedVirtualHost
+	conflicts         map[string][]Warning
+}
+
+type versionedVirtualHost struct {
+	virtualHost *envoyroutev3.VirtualHost
+	// version is empty when the virtual host couldn't be hashed, so it's hashed again along with the snapshot.
+	version string
+}
+
+func newFleetSnapshot(fleet Fleet) *fleetSnapshot {
+	return &fleetSnapshot{
+		fleet:             fleet,
+		dirty:             map[string]struct{}{},
+		ingresses:         map[string]cachedIngress{},
+		hostIngresses:     map[string]map[string]struct{}{},
+		chains:            []tlsFilterChain{},
+		virtualHosts:      map[string]versionedVirtualHost{},
+		httpsVirtualHosts: map[string]versionedVirtualHost{},
+		conflicts:         map[string][]Warning{},
+	}
+}
+
+// toFleetSnapshot builds the next snapshot of a fleet, along with the versions by type of its resources and the host
+// conflicts between the Ingresses it serves. c.mu must be held.
+func (c *Cache) toFleetSnapshot(s *fleetSnapshot) (cache.Snapshot, map[resource.Type]string, []Warning, error) {
+	dirtyHosts := s.updateIngresses(c.ingresses)
+
+	keys := make([]string, 0, len(s.ingresses))
+	for key := range s.ingresses {
+		keys = append(keys, key)
+	}
+	// Sorted, so conflicting TLS hosts are always resolved the same way.
+	sort.Strings(keys)
+
+	clustersResources := make([]cachetypes.Resource, 0)
+	endpointsResources := make([]cachetypes.Resource, 0)
+	tlsChains := make([]tlsFilterChain, 0)
+	samplings := map[uint32]struct{}{}
+	known := map[resource.Type]map[string]string{
+		resource.ClusterType:  {},
+		resource.EndpointType: {},
+		resource.RouteType:    {},
+	}
+
+	if collector := c.translator.newAccessLogCollectorCluster(); collector != nil {
+		clustersResources = append(clustersResources, collector)
+	}
+	if authz := c.translator.newExtAuthzCluster(); authz != nil {
+		clustersResources = append(clustersResources, authz)
+	}
+
+	for _, key := range keys {
+		cached := s.ingresses[key]
+		if cached.sampling != nil {
+			samplings[*cached.sampling] = struct{}{}
+		}
+		clustersResources = append(clustersResources, cached.clusters...)
+		endpointsResources = append(endpointsResources, cached.endpoints...)
+		tlsChains = append(tlsChains, cached.tlsChains...)
+		for name, version := range cached.clusterVersions {
+			known[resource.ClusterType][name] = version
+		}
+		for name, version := range cached.endpointVersions {
+			known[resource.EndpointType][name] = version
+		}
+	}
+
+	chains, secretNames := c.dedupTLSChains(tlsChains)
+	// Only the hosts whose routes are redirected to HTTPS differently get new virtual hosts.
+	if !reflect.DeepEqual(chains, s.chains) {
+		for host := range s.hostIngresses {
+			if chainsCover(chains, host) != chainsCover(s.chains, host) {
+				dirtyHosts[host] = struct{}{}
+			}
+		}
+		s.chains = chains
+	}
+	c.updateVirtualHosts(s, dirtyHosts)
+
+	secrets := make([]cachetypes.Resource, 0)
+	for _, name := range secretNames {
+		cached, _ := c.secrets.Get(name)
+		secret, err := c.translator.newSecret(name, cached.(v1.Secret))
+		if err != nil {
+			log.Printf("failed to translate secret: %v", err)
+			continue
+		}
+		secrets = append(secrets, secret)
+	}
+
+	listeners := make([]cachetypes.Resource, 0, 2)
+	routeConfigs := make([]cachetypes.Resource, 0, 2)
+
+	routeConfig := c.translator.newRouteConfig("defaultroute", sortedVirtualHosts(s.virtualHosts))
+	hcm := c.translator.newHTTPConnectionManager("ingress_http", routeConfig.Name, sortedSamplings(samplings))
+	listener, _ := c.translator.newHTTPListener(hcm)
+	listeners = append(listeners, listener)
+	routeConfigs = append(routeConfigs, routeConfig)
+	if version := virtualHostsVersion(s.virtualHosts); version != "" {
+		known[resource.RouteType][routeConfig.Name] = version
+	}
+
+	// Envoy rejects listeners without filter chains.
+	if len(chains) > 0 {
+		httpsRouteConfig := c.translator.newRouteConfig("defaultroute_https", sortedVirtualHosts(s.httpsVirtualHosts))
+		httpsHcm := c.translator.newHTTPConnectionManager("ingress_https", httpsRouteConfig.Name, sortedSamplings(samplings))
+		httpsListener, err := c.translator.newHTTPSListener(httpsHcm, chains)
+		if err != nil {
+			log.Printf("failed to create https listener: %v", err)
+		} else {
+			listeners = append(listeners, httpsListener)
+			routeConfigs = append(routeConfigs, httpsRouteConfig)
+			if version := virtualHostsVersion(s.httpsVirtualHosts); version != "" {
+				known[resource.RouteType][httpsRouteConfig.Name] = version
+			}
+		}
+	}
+
+	res := make(map[resource.Type][]cachetypes.Resource, 0)
+
+	res[resource.RouteType] = routeConfigs
+	res[resource.ListenerType] = listeners
+	res[resource.ClusterType] = clustersResources
+	res[resource.EndpointType] = endpointsResources
+	res[resource.SecretType] = secrets
+
+	conflicts := make([]Warning, 0)
+	for _, host := range sortedHosts(s.virtualHosts) {
+		conflicts = append(conflicts, s.conflicts[host]...)
+	}
+
+	snapshot, versions, err := newVersionedSnapshot(res, known)
+	return snapshot, versions, conflicts, err
+}
+
+// updateIngresses replaces the updated Ingresses served by the fleet with their latest translation, and returns the
+// hosts of their previous and current routes.
+func (s *fleetSnapshot) updateIngresses(ingresses *gocache.Cache) map[string]struct{} {
+	dirtyHosts := map[string]struct{}{}
+	for key := range s.dirty {
+		if previous, ok := s.ingresses[key]; ok {
+			for _, r := range previous.routes {
+				dirtyHosts[r.host] = struct{}{}
+				delete(s.hostIngresses[r.host], key)
+				if len(s.hostIngresses[r.host]) == 0 {
+					delete(s.hostIngresses, r.host)
+				}
+			}
+			delete(s.ingresses, key)
+		}
+
+		item, ok := ingresses.Get(key)
+		if !ok {
+			continue
+		}
+		cached := item.(cachedIngress)
+		if _, ok := cached.fleets[s.fleet.Name]; !ok {
+			continue
+		}
+		if cached.failover {
+			cached.clusters, cached.endpoints = fleetLoadAssignments(cached.clusters, cached.endpoints, s.fleet)
+			cached.clusterVersions, cached.endpointVersions = resourceVersions(cached.clusters), resourceVersions(cached.endpoints)
+		}
+		s.ingresses[key] = cached
+		for _, r := range cached.routes {
+			dirtyHosts[r.host] = struct{}{}
+			if s.hostIngresses[r.host] == nil {
+				s.hostIngresses[r.host] = map[string]struct{}{}
+			}
+			s.hostIngresses[r.host][key] = struct{}{}
+		}
+	}
+	s.dirty = map[string]struct{}{}
+	return dirtyHosts
+}
+
+// updateVirtualHosts merges the routes of the Ingresses into new virtual hosts for the given hosts.
+func (c *Cache) updateVirtualHosts(s *fleetSnapshot, hosts map[string]struct{}) {
+	for host := range hosts {
+		delete(s.virtualHosts, host)
+		delete(s.httpsVirtualHosts, host)
+		delete(s.conflicts, host)
+
+		routes := make([]hostRoute, 0)
+		for key := range s.hostIngresses[host] {
+			for _, r := range s.ingresses[key].routes {
+				if r.host == host {
+					routes = append(routes, r)
+				}
+			}
+		}
+		if len(routes) == 0 {
+			continue
+		}
+
+		// The plaintext listener redirects to HTTPS the hosts the TLS listener serves, so it gets its own routes.
+		virtualHosts, conflicts := c.translator.newVirtualHosts(c.translator.newHTTPSRedirectRoutes(routes, s.chains))
+		// The conflicts are the same as the plaintext ones.
+		httpsVirtualHosts, _ := c.translator.newVirtualHosts(routes)
+		for _, vh := range virtualHosts {
+			s.virtualHosts[host] = newVersionedVirtualHost(vh)
+		}
+		for _, vh := range httpsVirtualHosts {
+			s.httpsVirtualHosts[host] = newVersionedVirtualHost(vh)
+		}
+		if len(conflicts) > 0 {
+			s.conflicts[host] = conflicts
+		}
+	}
+}
+
+func newVersionedVirtualHost(vh *envoyroutev3.VirtualHost) versionedVirtualHost {
+	version, err := resourceVersion(vh)
+	if err != nil {
+		log.Printf("failed to hash virtual host %s: %v", vh.Name, err)
+	}
+	return versionedVirtualHost{virtualHost: vh, version: version}
+}
+
+// sortedVirtualHosts returns the virtual hosts sorted by host.
+func sortedVirtualHosts(virtualHosts map[string]versionedVirtualHost) []*envoyroutev3.VirtualHost {
+	sorted := make([]*envoyroutev3.VirtualHost, 0, len(virtualHosts))
+	for _, host := range sortedHosts(virtualHosts) {
+		sorted = append(sorted, virtualHosts[host].virtualHost)
+	}
+	return sorted
+}
+
+// virtualHostsVersion returns the version of a route configuration from the versions of its virtual hosts, the rest of
+// it being the same for all the snapshots. It returns an empty version if a virtual host couldn't be hashed.
+func virtualHostsVersion(virtualHosts map[string]versionedVirtualHost) string {
+	hash := sha256.New()
+	for _, host := range sortedHosts(virtualHosts) {
+		version := virtualHosts[host].version
+		if version == "" {
+			return ""
+		}
+		hash.Write([]byte(host + "=" + version + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// sortedHosts returns the hosts of the virtual hosts, sorted.
+func sortedHosts(virtualHosts map[string]versionedVirtualHost) []string {
+	hosts := make([]string, 0, len(virtualHosts))
+	for host := range virtualHosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
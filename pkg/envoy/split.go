@@ -0,0 +1,84 @@
+package envoy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// parseTrafficSplit parses the weights by cluster of the TrafficSplitAnnotation.
+func parseTrafficSplit(value string) (map[string]uint32, error) {
+	weights := map[string]uint32{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid cluster weight %q, expected cluster=weight", pair)
+		}
+		weight, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight for cluster %q: %v", parts[0], err)
+		}
+		weights[parts[0]] = uint32(weight)
+	}
+	return weights, nil
+}
+
+// leafClusterName returns the name of the Envoy cluster holding the endpoints of a single leaf of the Ingress.
+func leafClusterName(ingress networkingv1.Ingress, leaf Leaf) string {
+	return ingressToKey(ingress) + "--" + leaf.Cluster
+}
+
+// translateTrafficSplit returns an Envoy cluster per leaf, and the weighted clusters to route to them, when the Ingress
+// has the TrafficSplitAnnotation. Leaves with no weight still get an Envoy cluster, so they can be ramped up without
+// any other change.
+//
+// It returns no clusters, and no weighted clusters, if the Ingress doesn't split its traffic or no leaf has any weight,
+// like when the leaves with a weight are all unhealthy. The requests are then routed to all the leaves, including the
+// ones with no weight, which is reported in the returned error.
+func (t *translator) translateTrafficSplit(ingress networkingv1.Ingress, leaves []Leaf, mode, protocol string, connectTimeout time.Duration) ([]*envoyclusterv3.Cluster, *envoyroutev3.WeightedCluster, error) {
+	value, ok := ingress.Annotations[TrafficSplitAnnotation]
+	if !ok {
+		return nil, nil, nil
+	}
+	weights, err := parseTrafficSplit(value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %v", TrafficSplitAnnotation, err)
+	}
+
+	sorted := append([]Leaf(nil), leaves...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cluster < sorted[j].Cluster })
+
+	clusters := make([]*envoyclusterv3.Cluster, 0, len(sorted))
+	weighted := &envoyroutev3.WeightedCluster{}
+	total := uint32(0)
+	for _, leaf := range sorted {
+		name := leafClusterName(ingress, leaf)
+		localities := t.newLocalityLbEndpoints([]Leaf{leaf}, mode, protocol)
+		cluster := t.newCluster(name, connectTimeout, localities, discoveryType(localities))
+		clusters = append(clusters, cluster)
+
+		weighted.Clusters = append(weighted.Clusters, &envoyroutev3.WeightedCluster_ClusterWeight{
+			Name:   name,
+			Weight: wrapperspb.UInt32(weights[leaf.Cluster]),
+		})
+		total += weights[leaf.Cluster]
+	}
+
+	// Envoy requires the weights to add up to a positive total weight.
+	if total == 0 {
+		if len(sorted) == 0 {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("no ready leaf has a weight, routing to all the leaves")
+	}
+	weighted.TotalWeight = wrapperspb.UInt32(total)
+
+	return clusters, weighted, nil
+}
@@ -0,0 +1,125 @@
+package envoy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestParseTrafficSplit(t *testing.T) {
+	weights, err := parseTrafficSplit("cluster-a=90, cluster-b=10,cluster-c=0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := map[string]uint32{"cluster-a": 90, "cluster-b": 10, "cluster-c": 0}; !reflect.DeepEqual(weights, expected) {
+		t.Errorf("expected the weights %v, got %v", expected, weights)
+	}
+
+	for _, value := range []string{"", "cluster-a", "=10", "cluster-a=-1", "cluster-a=ten", "cluster-a=10,"} {
+		if _, err := parseTrafficSplit(value); err == nil {
+			t.Errorf("expected an error parsing %q", value)
+		}
+	}
+}
+
+func TestTranslateTrafficSplit(t *testing.T) {
+	leaves := []Leaf{
+		{Cluster: "cluster-b", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.2"}}},
+		{Cluster: "cluster-a", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}},
+		{Cluster: "cluster-c", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.3"}}},
+	}
+
+	for _, test := range []struct {
+		name   string
+		split  *string
+		leaves []Leaf
+		// weights of the leaf clusters, by kcp cluster, nil when the traffic isn't split.
+		weights map[string]uint32
+		err     bool
+	}{
+		{name: "no split", leaves: leaves},
+		{
+			name:    "split",
+			split:   pointer.StringPtr("cluster-a=90,cluster-b=10"),
+			leaves:  leaves,
+			weights: map[string]uint32{"cluster-a": 90, "cluster-b": 10, "cluster-c": 0},
+		},
+		{
+			name:    "unhealthy weighted leaf",
+			split:   pointer.StringPtr("cluster-a=90,cluster-b=10"),
+			leaves:  leaves[:1],
+			weights: map[string]uint32{"cluster-b": 10},
+		},
+		{name: "unhealthy weighted leaves", split: pointer.StringPtr("cluster-a=90,cluster-b=10"), leaves: leaves[2:], err: true},
+		{name: "no weight", split: pointer.StringPtr("cluster-a=0"), leaves: leaves, err: true},
+		{name: "no leaf", split: pointer.StringPtr("cluster-a=90")},
+		{name: "invalid split", split: pointer.StringPtr("cluster-a"), leaves: leaves, err: true},
+	} {
+		ingress := newTestIngress("split", "foo.com")
+		if test.split != nil {
+			ingress.Annotations[TrafficSplitAnnotation] = *test.split
+		}
+		clusters, weighted, err := newTestTranslator().translateTrafficSplit(ingress, test.leaves, LoadBalancingRoundRobin, BackendProtocolHTTP, 0)
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected error %t, got %v", test.name, test.err, err)
+		}
+
+		if test.weights == nil {
+			if clusters != nil || weighted != nil {
+				t.Errorf("%s: expected the traffic not to be split, got %v", test.name, weighted)
+			}
+			continue
+		}
+		weights := map[string]uint32{}
+		total := uint32(0)
+		for _, leaf := range test.leaves {
+			name := leafClusterName(ingress, leaf)
+			for _, cluster := range weighted.GetClusters() {
+				if cluster.Name == name {
+					weights[leaf.Cluster] = cluster.Weight.GetValue()
+					total += cluster.Weight.GetValue()
+				}
+			}
+		}
+		if !reflect.DeepEqual(weights, test.weights) {
+			t.Errorf("%s: expected the weights %v, got %v", test.name, test.weights, weights)
+		}
+		if weighted.TotalWeight.GetValue() != total {
+			t.Errorf("%s: expected the total weight %d, got %d", test.name, total, weighted.TotalWeight.GetValue())
+		}
+		// Every leaf gets a cluster, even without weight, so it can be ramped up.
+		if len(clusters) != len(test.leaves) {
+			t.Errorf("%s: expected a cluster per leaf, got %d", test.name, len(clusters))
+		}
+	}
+}
+
+func TestTrafficSplitHealthChecks(t *testing.T) {
+	tr := newTestTranslator()
+	tr.healthCheck = HealthCheckConfig{Protocol: HealthCheckTCP, Interval: 10 * time.Second, Timeout: 2 * time.Second, HealthyThreshold: 1, UnhealthyThreshold: 1}
+	leaves := []Leaf{
+		{Cluster: "cluster-a", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}},
+		{Cluster: "cluster-b", LoadBalancer: []v1.LoadBalancerIngress{{IP: "10.0.0.2"}}},
+	}
+
+	for _, split := range []bool{false, true} {
+		ingress := newTestIngress("split", "foo.com")
+		if split {
+			ingress.Annotations[TrafficSplitAnnotation] = "cluster-a=90,cluster-b=10"
+		}
+		clusters, _, _, _ := tr.translateIngress(ingress, leaves, nil)
+
+		for _, r := range clusters {
+			cluster := r.(*envoyclusterv3.Cluster)
+			// The gateways are only health checked through the clusters getting the traffic.
+			expected := !split || cluster.Name != ingressToKey(ingress)
+			if checked := len(cluster.HealthChecks) > 0; checked != expected {
+				t.Errorf("split %t: expected the health checking of cluster %s to be %t", split, cluster.Name, expected)
+			}
+		}
+	}
+}
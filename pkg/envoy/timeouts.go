@@ -0,0 +1,174 @@
+package envoy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// retryOnConditions are the Envoy retry conditions that can be set in the retry policy of a route.
+// Ref: https://www.envoyproxy.io/docs/envoy/latest/configuration/http/http_filters/router_filter#x-envoy-retry-on
+var retryOnConditions = map[string]bool{
+	"5xx":                    true,
+	"gateway-error":          true,
+	"reset":                  true,
+	"connect-failure":        true,
+	"envoy-ratelimited":      true,
+	"retriable-4xx":          true,
+	"refused-stream":         true,
+	"retriable-status-codes": true,
+	"retriable-headers":      true,
+}
+
+// TimeoutConfig configures the timeouts of the requests to the cluster gateways.
+type TimeoutConfig struct {
+	// Request is the timeout of the whole request, including retries, 0 disables it.
+	Request time.Duration
+	// Idle is the timeout of a request without activity, 0 keeps the Envoy default.
+	Idle time.Duration
+	// Connect is the timeout of the connections to the cluster gateways.
+	Connect time.Duration
+}
+
+// Validate returns an error if the configuration can't be translated.
+func (c TimeoutConfig) Validate() error {
+	if c.Request < 0 || c.Idle < 0 {
+		return fmt.Errorf("request and idle timeouts can't be negative")
+	}
+	if c.Connect <= 0 {
+		return fmt.Errorf("connect timeout must be positive")
+	}
+	return nil
+}
+
+// RetryConfig configures the retries of the requests to the cluster gateways.
+type RetryConfig struct {
+	// RetryOn is a comma separated list of Envoy retry conditions, like "5xx,reset". Empty disables retries.
+	RetryOn string
+	// NumRetries is the maximum number of retries of a request.
+	NumRetries uint32
+	// PerTryTimeout is the timeout of each try, 0 uses the request timeout.
+	PerTryTimeout time.Duration
+	// RetriableStatusCodes is a comma separated list of the statuses retried with the "retriable-status-codes"
+	// condition, and RetriableHeaders of the response headers retried with the "retriable-headers" condition.
+	RetriableStatusCodes string
+	RetriableHeaders     string
+}
+
+// Validate returns an error if the configuration can't be translated.
+func (c RetryConfig) Validate() error {
+	conditions := map[string]bool{}
+	for _, condition := range splitList(c.RetryOn) {
+		if !retryOnConditions[condition] {
+			return fmt.Errorf("unknown retry condition %q", condition)
+		}
+		conditions[condition] = true
+	}
+	if c.PerTryTimeout < 0 {
+		return fmt.Errorf("per try timeout can't be negative")
+	}
+
+	codes, err := parseStatusCodes(c.RetriableStatusCodes)
+	if err != nil {
+		return err
+	}
+	if conditions["retriable-status-codes"] && len(codes) == 0 {
+		return fmt.Errorf("the retriable-status-codes retry condition is set without retriable status codes")
+	}
+	headers := splitList(c.RetriableHeaders)
+	for _, header := range headers {
+		if !httpToken.MatchString(header) {
+			return fmt.Errorf("invalid retriable header %q", header)
+		}
+	}
+	if conditions["retriable-headers"] && len(headers) == 0 {
+		return fmt.Errorf("the retriable-headers retry condition is set without retriable headers")
+	}
+	return nil
+}
+
+// parseStatusCodes parses a comma separated list of HTTP statuses.
+func parseStatusCodes(value string) ([]uint32, error) {
+	codes := make([]uint32, 0)
+	for _, entry := range splitList(value) {
+		code, err := strconv.ParseUint(entry, 10, 32)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid retriable status code %q", entry)
+		}
+		codes = append(codes, uint32(code))
+	}
+	return codes, nil
+}
+
+// timeoutConfig returns the timeouts of the Ingress: the controller configuration overridden by the annotations of
+// the Ingress.
+func (t *translator) timeoutConfig(ingress networkingv1.Ingress) (TimeoutConfig, error) {
+	config := t.timeouts
+	for _, err := range []error{
+		parseDurationAnnotation(ingress.Annotations, RequestTimeoutAnnotation, &config.Request),
+		parseDurationAnnotation(ingress.Annotations, IdleTimeoutAnnotation, &config.Idle),
+		parseDurationAnnotation(ingress.Annotations, ConnectTimeoutAnnotation, &config.Connect),
+	} {
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, config.Validate()
+}
+
+// retryConfig returns the retry policy of the Ingress: the controller configuration overridden by the annotations of
+// the Ingress.
+func (t *translator) retryConfig(ingress networkingv1.Ingress) (RetryConfig, error) {
+	config := t.retries
+	if retryOn, ok := ingress.Annotations[RetryOnAnnotation]; ok {
+		config.RetryOn = retryOn
+	}
+	if codes, ok := ingress.Annotations[RetriableStatusCodesAnnotation]; ok {
+		config.RetriableStatusCodes = codes
+	}
+	if headers, ok := ingress.Annotations[RetriableHeadersAnnotation]; ok {
+		config.RetriableHeaders = headers
+	}
+	for _, err := range []error{
+		parseUint32Annotation(ingress.Annotations, NumRetriesAnnotation, &config.NumRetries),
+		parseDurationAnnotation(ingress.Annotations, PerTryTimeoutAnnotation, &config.PerTryTimeout),
+	} {
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, config.Validate()
+}
+
+// setRoutePolicy sets the timeouts and the retry policy of the route.
+func setRoutePolicy(route *envoyroutev3.RouteAction, timeouts TimeoutConfig, retries RetryConfig) {
+	route.Timeout = durationpb.New(timeouts.Request)
+	if timeouts.Idle > 0 {
+		route.IdleTimeout = durationpb.New(timeouts.Idle)
+	}
+
+	if retries.RetryOn == "" {
+		return
+	}
+	route.RetryPolicy = &envoyroutev3.RetryPolicy{
+		RetryOn:    strings.ReplaceAll(retries.RetryOn, " ", ""),
+		NumRetries: wrapperspb.UInt32(retries.NumRetries),
+	}
+	if retries.PerTryTimeout > 0 {
+		route.RetryPolicy.PerTryTimeout = durationpb.New(retries.PerTryTimeout)
+	}
+	// The configuration is validated.
+	route.RetryPolicy.RetriableStatusCodes, _ = parseStatusCodes(retries.RetriableStatusCodes)
+	for _, header := range splitList(strings.ToLower(retries.RetriableHeaders)) {
+		route.RetryPolicy.RetriableHeaders = append(route.RetryPolicy.RetriableHeaders, &envoyroutev3.HeaderMatcher{
+			Name:                 header,
+			HeaderMatchSpecifier: &envoyroutev3.HeaderMatcher_PresentMatch{PresentMatch: true},
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+)
+
+func TestRetryConfig(t *testing.T) {
+	for _, test := range []struct {
+		annotations map[string]string
+		valid       bool
+		codes       []uint32
+		headers     []string
+	}{
+		{annotations: map[string]string{RetryOnAnnotation: "5xx,reset"}, valid: true},
+		{annotations: map[string]string{RetryOnAnnotation: "retriable-status-codes", RetriableStatusCodesAnnotation: "409, 503"}, valid: true, codes: []uint32{409, 503}},
+		{annotations: map[string]string{RetryOnAnnotation: "retriable-headers", RetriableHeadersAnnotation: "x-retry,x-upstream-busy"}, valid: true, headers: []string{"x-retry", "x-upstream-busy"}},
+		{annotations: map[string]string{RetryOnAnnotation: "retriable-status-codes"}, valid: false},
+		{annotations: map[string]string{RetryOnAnnotation: "retriable-headers"}, valid: false},
+		{annotations: map[string]string{RetryOnAnnotation: "retriable-status-codes", RetriableStatusCodesAnnotation: "5xx"}, valid: false},
+		{annotations: map[string]string{RetryOnAnnotation: "retriable-status-codes", RetriableStatusCodesAnnotation: "600"}, valid: false},
+		{annotations: map[string]string{RetryOnAnnotation: "retriable-headers", RetriableHeadersAnnotation: "x retry"}, valid: false},
+		{annotations: map[string]string{RetryOnAnnotation: "unknown"}, valid: false},
+	} {
+		tr := newTestTranslator()
+		ingress := newTestIngress("foo", "foo.com")
+		ingress.Annotations = test.annotations
+
+		config, err := tr.retryConfig(ingress)
+		if (err == nil) != test.valid {
+			t.Errorf("unexpected validation of %v: %v", test.annotations, err)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+
+		route := &envoyroutev3.RouteAction{}
+		setRoutePolicy(route, TimeoutConfig{}, config)
+		if codes := route.RetryPolicy.RetriableStatusCodes; len(codes) != len(test.codes) || len(codes) > 0 && !reflect.DeepEqual(codes, test.codes) {
+			t.Errorf("expected the retriable status codes %v for %v, got %v", test.codes, test.annotations, codes)
+		}
+		headers := make([]string, 0)
+		for _, header := range route.RetryPolicy.RetriableHeaders {
+			if !header.GetPresentMatch() {
+				t.Errorf("expected the retriable header %q to match when present", header.Name)
+			}
+			headers = append(headers, header.Name)
+		}
+		if len(headers) != len(test.headers) || len(headers) > 0 && !reflect.DeepEqual(headers, test.headers) {
+			t.Errorf("expected the retriable headers %v for %v, got %v", test.headers, test.annotations, headers)
+		}
+	}
+}
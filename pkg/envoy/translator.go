@@ -1,8 +1,12 @@
 package envoy
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
@@ -10,95 +14,474 @@ import (
 	envoyendpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	envoylistenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoytlsinspectorv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/tls_inspector/v3"
 	envoyfilterhcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoytlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	envoymatcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
 	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
+	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 )
 
+const (
+	// PathTypePrefix matches ImplementationSpecific paths as Envoy prefixes, without splitting the path in elements.
+	PathTypePrefix = "Prefix"
+	// PathTypeRegex matches ImplementationSpecific paths as RE2 regular expressions on the full path.
+	PathTypeRegex = "Regex"
+)
+
+type TranslatorConfig struct {
+	EnvoyListenPort    *uint
+	EnvoyTLSListenPort *uint
+	// UpstreamPort is the HTTP port of the cluster gateways, used when their status doesn't list their ports.
+	// UpstreamTLSPort is their HTTPS port, TLS is originated to the gateways only exposing it.
+	UpstreamPort    *uint
+	UpstreamTLSPort *uint
+	// UpstreamTLS is how the certificates of the gateways serving HTTPS are verified.
+	UpstreamTLS *UpstreamTLSConfig
+	// IPFamily is the IP family Envoy listens on and reaches the cluster gateways with, one of IPFamilyIPv4,
+	// IPFamilyIPv6 or IPFamilyDualStack.
+	IPFamily *string
+	// ImplementationSpecificPathType is how paths with the ImplementationSpecific type are matched,
+	// either PathTypePrefix or PathTypeRegex.
+	ImplementationSpecificPathType *string
+	// HealthCheck is the default active health checking of the cluster gateways.
+	HealthCheck *HealthCheckConfig
+	// OutlierDetection and CircuitBreakers are the default protections against misbehaving cluster gateways.
+	OutlierDetection *OutlierDetectionConfig
+	CircuitBreakers  *CircuitBreakersConfig
+	// Timeouts and Retries are the default policy of the requests to the cluster gateways.
+	Timeouts *TimeoutConfig
+	Retries  *RetryConfig
+	// AccessLog configures the access logs of the requests.
+	AccessLog *AccessLogConfig
+	// RateLimitStatus is the default status of the requests over the rate limit of their Ingress.
+	RateLimitStatus *uint
+	// ExtAuthz is the external authorization service of the Ingresses enabling it.
+	ExtAuthz *ExtAuthzConfig
+}
+
 type translator struct {
-	envoyListenPort *uint
+	envoyListenPort                *uint
+	envoyTLSListenPort             *uint
+	upstreamPlainPort              uint32
+	upstreamTLSPort                uint32
+	upstreamTLS                    UpstreamTLSConfig
+	ipFamily                       string
+	implementationSpecificPathType string
+	healthCheck                    HealthCheckConfig
+	outlierDetection               OutlierDetectionConfig
+	circuitBreakers                CircuitBreakersConfig
+	timeouts                       TimeoutConfig
+	retries                        RetryConfig
+	accessLog                      AccessLogConfig
+	rateLimitStatus                uint32
+	extAuthz                       ExtAuthzConfig
 }
 
-func NewTranslator(envoyListenPort *uint) *translator {
+func NewTranslator(config *TranslatorConfig) *translator {
 	return &translator{
-		envoyListenPort: envoyListenPort,
+		envoyListenPort:                config.EnvoyListenPort,
+		envoyTLSListenPort:             config.EnvoyTLSListenPort,
+		upstreamPlainPort:              uint32(*config.UpstreamPort),
+		upstreamTLSPort:                uint32(*config.UpstreamTLSPort),
+		upstreamTLS:                    *config.UpstreamTLS,
+		ipFamily:                       *config.IPFamily,
+		implementationSpecificPathType: *config.ImplementationSpecificPathType,
+		healthCheck:                    *config.HealthCheck,
+		outlierDetection:               *config.OutlierDetection,
+		circuitBreakers:                *config.CircuitBreakers,
+		timeouts:                       *config.Timeouts,
+		retries:                        *config.Retries,
+		accessLog:                      *config.AccessLog,
+		rateLimitStatus:                uint32(*config.RateLimitStatus),
+		extAuthz:                       *config.ExtAuthz,
 	}
 }
 
-func (t *translator) translateIngress(ingress networkingv1.Ingress) ([]cachetypes.Resource, []*envoyroutev3.VirtualHost) {
+// tlsFilterChain holds the SNI hosts that are served with the certificate of a given Secret.
+type tlsFilterChain struct {
+	hosts      []string
+	secretName string
+}
+
+// translateIngress returns the Envoy clusters of the Ingress, the endpoints of its EDS clusters, and its routes by host,
+// along with the problems found when translating it. Routes from all the Ingresses are merged into virtual hosts by
+// newVirtualHosts.
+func (t *translator) translateIngress(ingress networkingv1.Ingress, leaves []Leaf, services []v1.Service) ([]cachetypes.Resource, []cachetypes.Resource, []hostRoute, []Warning) {
+	warnings := make([]Warning, 0)
+
+	mode, err := loadBalancingMode(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "%v, using %q", err, mode))
+	}
+
+	timeouts, err := t.timeoutConfig(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring timeout annotations: %v", err))
+		timeouts = t.timeouts
+	}
+
+	retries, err := t.retryConfig(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring retry annotations: %v", err))
+		retries = t.retries
+	}
+
+	protocol, err := backendProtocol(ingress, services)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "%v, using %q", err, protocol))
+	}
+
+	localities := t.newLocalityLbEndpoints(leaves, mode, protocol)
+	cluster := t.newCluster(ingressToKey(ingress), timeouts.Connect, localities, discoveryType(localities))
+	t.setLoadBalancing(cluster, mode)
+	envoyClusters := []*envoyclusterv3.Cluster{cluster}
+
+	// Route to the cluster holding all the leaves, unless the traffic is split between them.
+	leafClusters, weighted, err := t.translateTrafficSplit(ingress, leaves, mode, protocol, timeouts.Connect)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring traffic split: %v", err))
+	}
+	envoyClusters = append(envoyClusters, leafClusters...)
+
+	healthCheck, err := t.healthCheckConfig(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring health check annotations: %v", err))
+		healthCheck = t.healthCheckDefaults(ingress)
+	}
+
+	// Without host, Envoy sends the cluster name, which gateways are unlikely to route.
+	missingHealthCheckHost := healthCheck.Protocol == HealthCheckHTTP && healthCheck.Host == ""
+	if missingHealthCheckHost {
+		warnings = append(warnings, newWarning(ingress, ReasonMissingHealthCheckHost,
+			"the Ingress has no host to health check its gateways with, set %s: the gateways are checked with their hostname, or %q when reached by IP",
+			HealthCheckHostAnnotation, ingressToKey(ingress)))
+	}
 
-	// TODO(jmprusi): Hardcoded port, also, not TLS support. Review
-	endpoints := make([]*envoyendpointv3.LbEndpoint, 0)
-	for _, lb := range ingress.Status.LoadBalancer.Ingress {
-		endpoint := &envoyendpointv3.LbEndpoint{}
-		if lb.Hostname != "" {
-			endpoint = t.newLBEndpoint(lb.Hostname, 80)
-		} else if lb.IP != "" {
-			endpoint = t.newLBEndpoint(lb.IP, 80)
+	outlierDetection, err := t.outlierDetectionConfig(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring outlier detection annotations: %v", err))
+		outlierDetection = t.outlierDetection
+	}
+
+	circuitBreakers, err := t.circuitBreakersConfig(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring circuit breaker annotations: %v", err))
+		circuitBreakers = t.circuitBreakers
+	}
+
+	for _, c := range envoyClusters {
+		// The cluster holding all the leaves gets no traffic when it's split, and health checking it would check every
+		// gateway twice.
+		if weighted == nil || c != cluster {
+			c.HealthChecks = newHealthChecks(healthCheck)
+			if missingHealthCheckHost {
+				setHealthCheckHostnames(c.LoadAssignment.GetEndpoints())
+			}
+		}
+		c.OutlierDetection = newOutlierDetection(outlierDetection)
+		c.CircuitBreakers = newCircuitBreakers(circuitBreakers)
+		if err := t.setUpstreamProtocol(c, protocol, defaultHost(ingress)); err != nil {
+			log.Printf("failed to configure upstream protocol of cluster %s: %v", c.Name, err)
 		}
-		endpoints = append(endpoints, endpoint)
 	}
 
-	//TODO(jmprusi): HTTP2 is set to false always, also allow for configuration of the timeout
-	cluster := t.newCluster(ingressToKey(ingress), 2*time.Second, endpoints, envoyclusterv3.Cluster_STRICT_DNS)
-	cluster.DnsLookupFamily = envoyclusterv3.Cluster_V4_ONLY
+	clusters := make([]cachetypes.Resource, 0, len(envoyClusters))
+	endpoints := make([]cachetypes.Resource, 0, len(envoyClusters))
+	for _, c := range envoyClusters {
+		// The endpoints of EDS clusters are published on their own, so leaf status changes don't modify the clusters,
+		// and Envoy doesn't have to rebuild them and drain their connections.
+		if c.GetType() == envoyclusterv3.Cluster_EDS {
+			endpoints = append(endpoints, c.LoadAssignment)
+			c.LoadAssignment = nil
+			c.EdsClusterConfig = &envoyclusterv3.Cluster_EdsClusterConfig{EdsConfig: adsConfigSource()}
+		}
+		clusters = append(clusters, c)
+	}
 
-	virtualHosts := make([]*envoyroutev3.VirtualHost, 0)
-	routes := make([]*envoyroutev3.Route, 0)
-	domains := make([]string, 0)
+	routes := make([]hostRoute, 0)
+	// hosts are the hosts of the rules, in order, and the catch-all host.
+	hosts, seen := []string{catchAllHost}, map[string]bool{catchAllHost: true}
 
-	//TODO(jmprusi): We are ignoring the path type, we need to review this.
 	for i, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
 
-		// TODO(jmprusi): If the host is empty we just ignore the rule, not ideal.
-		if rule.HTTP.Paths == nil || rule.Host == "" {
-			break
+		// Rules without a host apply to any host not claimed by another rule.
+		host := rule.Host
+		if host == "" {
+			host = catchAllHost
+		} else if !seen[host] {
+			hosts, seen[host] = append(hosts, host), true
 		}
 
 		for _, path := range rule.HTTP.Paths {
-			route := &envoyroutev3.Route{
-				Name: ingress.Name + ingress.Namespace + strconv.Itoa(i),
-				Match: &envoyroutev3.RouteMatch{
-					PathSpecifier: &envoyroutev3.RouteMatch_Prefix{
-						Prefix: path.Path,
-					},
-				},
-				Action: &envoyroutev3.Route_Route{
-					Route: &envoyroutev3.RouteAction{
-						ClusterSpecifier: &envoyroutev3.RouteAction_Cluster{
-							Cluster: ingressToKey(ingress),
-						},
-						Timeout: &durationpb.Duration{Seconds: 0},
-						UpgradeConfigs: []*envoyroutev3.RouteAction_UpgradeConfig{{
-							UpgradeType: "websocket",
-							Enabled:     wrapperspb.Bool(true),
-						}},
-					},
+			match, err := t.newRouteMatch(path)
+			if err != nil {
+				// An invalid route would make Envoy reject the whole route configuration.
+				warnings = append(warnings, newWarning(ingress, ReasonInvalidPath, "ignoring path %q: %v", path.Path, err))
+				continue
+			}
+
+			// Envoy wildcard domains match any number of labels, while Ingress wildcards only match a single one.
+			if strings.HasPrefix(host, "*.") {
+				match.Headers = append(match.Headers, newWildcardHostMatcher(host))
+			}
+
+			routes = append(routes, hostRoute{
+				host:    host,
+				path:    path,
+				route:   t.newRoute(ingress.Name+ingress.Namespace+strconv.Itoa(i), match, ingressToKey(ingress), weighted),
+				ingress: ingress,
+			})
+		}
+	}
+
+	// The default backend gets the requests that didn't match any rule: the requests to the hosts of the rules, and to
+	// any host not claimed by another rule.
+	if ingress.Spec.DefaultBackend != nil {
+		prefix := networkingv1.PathTypePrefix
+		for _, host := range hosts {
+			match := &envoyroutev3.RouteMatch{PathSpecifier: &envoyroutev3.RouteMatch_Prefix{Prefix: "/"}}
+			if strings.HasPrefix(host, "*.") {
+				match.Headers = append(match.Headers, newWildcardHostMatcher(host))
+			}
+			routes = append(routes, hostRoute{
+				host:           host,
+				path:           networkingv1.HTTPIngressPath{Path: "/", PathType: &prefix},
+				route:          t.newRoute(ingress.Name+ingress.Namespace+"default", match, ingressToKey(ingress), weighted),
+				ingress:        ingress,
+				defaultBackend: true,
+			})
+		}
+	}
+
+	sampling, err := accessLogSampling(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "logging all requests: %v", err))
+	}
+
+	var rateLimit *anypb.Any
+	rateLimitConfig, err := t.rateLimitConfig(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring rate limit: %v", err))
+	} else if rateLimitConfig != nil {
+		if rateLimit, err = newLocalRateLimit(*rateLimitConfig); err != nil {
+			log.Printf("failed to configure the rate limit of Ingress %s: %v", ingressToKey(ingress), err)
+		}
+	}
+
+	cors, err := corsPolicy(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring CORS policy: %v", err))
+	}
+
+	headers, err := ingressHeaderOptions(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring header annotations: %v", err))
+	}
+
+	host, err := hostRewrite(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring host rewrite: %v", err))
+	}
+
+	redirect, err := ingressRedirect(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring redirect: %v", err))
+	}
+
+	redirectToHTTPS, err := httpsRedirectEnabled(ingress)
+	if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "redirecting to HTTPS: %v", err))
+	}
+
+	denied := false
+	extAuthz, err := t.extAuthzRouteConfig(ingress)
+	if errors.Is(err, errExtAuthzUnavailable) {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "denying all requests: %v", err))
+		denied = true
+	} else if err != nil {
+		warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "using the default authorization settings: %v", err))
+	}
+
+	for i, r := range routes {
+		setRoutePolicy(r.route.GetRoute(), timeouts, retries)
+		if cors != nil {
+			r.route.GetRoute().Cors = cors
+		}
+		setRouteHeaders(r.route, headers)
+		if host != "" {
+			setHostRewrite(r.route.GetRoute(), host)
+		}
+		if target, ok := ingress.Annotations[RewriteTargetAnnotation]; ok {
+			if err := t.setPathRewrite(r.route.GetRoute(), r.path, target); err != nil {
+				warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring rewrite target of path %q: %v", r.path.Path, err))
+			}
+		}
+		routes[i].httpsRedirect = redirectToHTTPS && ingressTLSCovers(ingress, r.host)
+		// The policies set above are dropped along with the route action, but the per-filter configurations still apply.
+		if redirect != nil {
+			if action, err := t.newRedirect(r.path, *redirect); err != nil {
+				warnings = append(warnings, newWarning(ingress, ReasonInvalidAnnotation, "ignoring redirect of path %q: %v", r.path.Path, err))
+			} else {
+				r.route.Action = &envoyroutev3.Route_Redirect{Redirect: action}
+			}
+		}
+		if denied {
+			setRouteDenied(r.route)
+		} else if extAuthz != nil {
+			setRouteExtAuthz(r.route, extAuthz)
+		}
+		if sampling != nil {
+			setAccessLogSampling(r.route, *sampling)
+		}
+		if rateLimit != nil && rateLimitConfig.Scope == RateLimitScopeHost {
+			routes[i].hostRateLimit = rateLimit
+		} else if rateLimit != nil {
+			setRouteRateLimit(r.route, rateLimit)
+		}
+	}
+
+	return clusters, endpoints, routes, warnings
+}
+
+// newRoute returns a route to the weighted clusters if set, or to the given cluster otherwise.
+func (t *translator) newRoute(name string, match *envoyroutev3.RouteMatch, cluster string, weighted *envoyroutev3.WeightedCluster) *envoyroutev3.Route {
+	route := &envoyroutev3.Route{
+		Name:  name,
+		Match: match,
+		Action: &envoyroutev3.Route_Route{
+			Route: &envoyroutev3.RouteAction{
+				ClusterSpecifier: &envoyroutev3.RouteAction_Cluster{
+					Cluster: cluster,
 				},
+				UpgradeConfigs: []*envoyroutev3.RouteAction_UpgradeConfig{{
+					UpgradeType: "websocket",
+					Enabled:     wrapperspb.Bool(true),
+				}},
+			},
+		},
+	}
+
+	if weighted != nil {
+		route.GetRoute().ClusterSpecifier = &envoyroutev3.RouteAction_WeightedClusters{WeightedClusters: weighted}
+	}
+	return route
+}
+
+// newWildcardHostMatcher returns a match of the authority against a wildcard host, where the wildcard only matches a
+// single DNS label: "*.foo.com" matches "bar.foo.com", but not "baz.bar.foo.com".
+func newWildcardHostMatcher(host string) *envoyroutev3.HeaderMatcher {
+	return &envoyroutev3.HeaderMatcher{
+		Name: ":authority",
+		HeaderMatchSpecifier: &envoyroutev3.HeaderMatcher_SafeRegexMatch{
+			SafeRegexMatch: &envoymatcherv3.RegexMatcher{
+				EngineType: &envoymatcherv3.RegexMatcher_GoogleRe2{GoogleRe2: &envoymatcherv3.RegexMatcher_GoogleRE2{}},
+				// Hosts are case insensitive, and the authority may have a port.
+				Regex: "(?i)[^.]+" + regexp.QuoteMeta(strings.TrimPrefix(host, "*")) + "(:[0-9]+)?",
+			},
+		},
+	}
+}
+
+// newRouteMatch translates the path of an Ingress rule following its path type:
+//   - Exact matches the path exactly.
+//   - Prefix matches the path split in elements by "/", so "/foo" matches "/foo" and "/foo/bar" but not "/foobar".
+//     A trailing "/" is ignored.
+//   - ImplementationSpecific is matched as an Envoy prefix or a regular expression, depending on the configuration.
+func (t *translator) newRouteMatch(path networkingv1.HTTPIngressPath) (*envoyroutev3.RouteMatch, error) {
+	switch pathType(path) {
+	case networkingv1.PathTypeExact:
+		return &envoyroutev3.RouteMatch{
+			PathSpecifier: &envoyroutev3.RouteMatch_Path{Path: path.Path},
+		}, nil
+
+	case networkingv1.PathTypePrefix:
+		prefix := strings.TrimRight(path.Path, "/")
+		if prefix == "" {
+			// "/" matches every path.
+			return &envoyroutev3.RouteMatch{
+				PathSpecifier: &envoyroutev3.RouteMatch_Prefix{Prefix: "/"},
+			}, nil
+		}
+		return newRegexRouteMatch(regexp.QuoteMeta(prefix) + "(/.*)?"), nil
+
+	case networkingv1.PathTypeImplementationSpecific:
+		if t.implementationSpecificPathType == PathTypeRegex {
+			if _, err := regexp.Compile(path.Path); err != nil {
+				return nil, err
 			}
-			routes = append(routes, route)
+			return newRegexRouteMatch(path.Path), nil
 		}
-		domains = append(domains, rule.Host, rule.Host+":*")
+		return &envoyroutev3.RouteMatch{
+			PathSpecifier: &envoyroutev3.RouteMatch_Prefix{Prefix: path.Path},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown path type %q", pathType(path))
+}
+
+// newRegexRouteMatch returns a match of the full path against a RE2 regular expression.
+func newRegexRouteMatch(regex string) *envoyroutev3.RouteMatch {
+	return &envoyroutev3.RouteMatch{
+		PathSpecifier: &envoyroutev3.RouteMatch_SafeRegex{
+			SafeRegex: &envoymatcherv3.RegexMatcher{
+				EngineType: &envoymatcherv3.RegexMatcher_GoogleRe2{GoogleRe2: &envoymatcherv3.RegexMatcher_GoogleRE2{}},
+				Regex:      regex,
+			},
+		},
 	}
+}
 
-	vh := &envoyroutev3.VirtualHost{
-		Name:    ingressToKey(ingress),
-		Domains: domains,
-		Routes:  routes,
+// translateTLS returns a filter chain for each of the TLS entries of the Ingress.
+// TLS entries without hosts apply to any SNI host.
+func (t *translator) translateTLS(ingress networkingv1.Ingress) []tlsFilterChain {
+	chains := make([]tlsFilterChain, 0, len(ingress.Spec.TLS))
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName == "" {
+			continue
+		}
+		chains = append(chains, tlsFilterChain{
+			hosts:      tls.Hosts,
+			secretName: secretToKey(ingress.Namespace, ingress.ClusterName, tls.SecretName),
+		})
 	}
+	return chains
+}
 
-	virtualHosts = append(virtualHosts, vh)
+// newSecret translates a kubernetes.io/tls Secret into an Envoy TLS certificate.
+func (t *translator) newSecret(name string, secret v1.Secret) (*envoytlsv3.Secret, error) {
+	cert, key := secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey]
+	if len(cert) == 0 || len(key) == 0 {
+		return nil, fmt.Errorf("secret %q is missing %q or %q", name, v1.TLSCertKey, v1.TLSPrivateKeyKey)
+	}
 
-	return []cachetypes.Resource{cluster}, virtualHosts
+	return &envoytlsv3.Secret{
+		Name: name,
+		Type: &envoytlsv3.Secret_TlsCertificate{
+			TlsCertificate: &envoytlsv3.TlsCertificate{
+				CertificateChain: &envoycorev3.DataSource{
+					Specifier: &envoycorev3.DataSource_InlineBytes{InlineBytes: cert},
+				},
+				PrivateKey: &envoycorev3.DataSource{
+					Specifier: &envoycorev3.DataSource_InlineBytes{InlineBytes: key},
+				},
+			},
+		},
+	}, nil
 }
 
-func (t *translator) newLBEndpoint(ip string, port uint32) *envoyendpointv3.LbEndpoint {
-	return &envoyendpointv3.LbEndpoint{
+// newLBEndpoint returns an endpoint of a cluster gateway, marked as serving HTTPS when tls is set.
+func (t *translator) newLBEndpoint(ip string, port uint32, tls bool) *envoyendpointv3.LbEndpoint {
+	endpoint := &envoyendpointv3.LbEndpoint{
 		HostIdentifier: &envoyendpointv3.LbEndpoint_Endpoint{
 			Endpoint: &envoyendpointv3.Endpoint{
 				Address: &envoycorev3.Address{
@@ -109,19 +492,22 @@ func (t *translator) newLBEndpoint(ip string, port uint32) *envoyendpointv3.LbEn
 							PortSpecifier: &envoycorev3.SocketAddress_PortValue{
 								PortValue: port,
 							},
-							Ipv4Compat: true,
 						},
 					},
 				},
 			},
 		},
 	}
+	if tls {
+		endpoint.Metadata = upstreamTLSMetadata()
+	}
+	return endpoint
 }
 
 func (t *translator) newCluster(
 	name string,
 	connectTimeout time.Duration,
-	endpoints []*envoyendpointv3.LbEndpoint,
+	localities []*envoyendpointv3.LocalityLbEndpoints,
 	discoveryType envoyclusterv3.Cluster_DiscoveryType) *envoyclusterv3.Cluster {
 
 	return &envoyclusterv3.Cluster{
@@ -129,12 +515,11 @@ func (t *translator) newCluster(
 		ClusterDiscoveryType: &envoyclusterv3.Cluster_Type{
 			Type: discoveryType,
 		},
-		ConnectTimeout: durationpb.New(connectTimeout),
+		ConnectTimeout:  durationpb.New(connectTimeout),
+		DnsLookupFamily: t.dnsLookupFamily(),
 		LoadAssignment: &envoyendpointv3.ClusterLoadAssignment{
 			ClusterName: name,
-			Endpoints: []*envoyendpointv3.LocalityLbEndpoints{{
-				LbEndpoints: endpoints,
-			}},
+			Endpoints:   localities,
 		},
 	}
 }
@@ -151,8 +536,39 @@ func (t *translator) newRouteConfig(name string, virtualHosts []*envoyroutev3.Vi
 	}
 }
 
-func (t *translator) newHTTPConnectionManager(routeConfigName string) *envoyfilterhcmv3.HttpConnectionManager {
-	filters := make([]*envoyfilterhcmv3.HttpFilter, 0, 1)
+// newHTTPConnectionManager returns the HTTP connection manager of a listener, logging the given percentages of the
+// requests of the Ingresses setting a sampling.
+func (t *translator) newHTTPConnectionManager(statPrefix, routeConfigName string, samplings []uint32) *envoyfilterhcmv3.HttpConnectionManager {
+	filters := make([]*envoyfilterhcmv3.HttpFilter, 0, 6)
+
+	// First, so the requests answered by the following filters are sampled too.
+	samplingFilter, err := t.newAccessLogSamplingFilter(samplings)
+	if err != nil {
+		log.Printf("failed to configure the access log sampling filter: %v", err)
+	} else if samplingFilter != nil {
+		filters = append(filters, samplingFilter)
+	}
+
+	rateLimitFilter, err := newLocalRateLimitFilter()
+	if err != nil {
+		log.Printf("failed to configure the rate limit filter: %v", err)
+	} else {
+		filters = append(filters, rateLimitFilter)
+	}
+
+	// Preflight requests are answered by the CORS filter, before being checked by the authorization service.
+	corsFilter, err := newCORSFilter()
+	if err != nil {
+		log.Printf("failed to configure the CORS filter: %v", err)
+	} else {
+		filters = append(filters, corsFilter)
+	}
+
+	extAuthzFilters, err := t.newExtAuthzFilters()
+	if err != nil {
+		log.Printf("failed to configure the external authorization filters: %v", err)
+	}
+	filters = append(filters, extAuthzFilters...)
 
 	// Append the Router filter at the end.
 	filters = append(filters, &envoyfilterhcmv3.HttpFilter{
@@ -161,17 +577,14 @@ func (t *translator) newHTTPConnectionManager(routeConfigName string) *envoyfilt
 
 	return &envoyfilterhcmv3.HttpConnectionManager{
 		CodecType:   envoyfilterhcmv3.HttpConnectionManager_AUTO,
-		StatPrefix:  "ingress_http",
+		StatPrefix:  statPrefix,
 		HttpFilters: filters,
+		AccessLog:   t.newAccessLogs(samplings),
+		// Virtual host domains don't include the port, as Envoy doesn't allow more than one wildcard in a domain.
+		StripPortMode: &envoyfilterhcmv3.HttpConnectionManager_StripAnyHostPort{StripAnyHostPort: true},
 		RouteSpecifier: &envoyfilterhcmv3.HttpConnectionManager_Rds{
 			Rds: &envoyfilterhcmv3.Rds{
-				ConfigSource: &envoycorev3.ConfigSource{
-					ResourceApiVersion: resource.DefaultAPIVersion,
-					ConfigSourceSpecifier: &envoycorev3.ConfigSource_Ads{
-						Ads: &envoycorev3.AggregatedConfigSource{},
-					},
-					InitialFetchTimeout: durationpb.New(10 * time.Second),
-				},
+				ConfigSource:    adsConfigSource(),
 				RouteConfigName: routeConfigName,
 			},
 		},
@@ -179,7 +592,7 @@ func (t *translator) newHTTPConnectionManager(routeConfigName string) *envoyfilt
 }
 
 func (t *translator) newHTTPListener(manager *envoyfilterhcmv3.HttpConnectionManager) (*envoylistenerv3.Listener, error) {
-	managerAny, err := anypb.New(manager)
+	managerAny, err := marshalAny(manager)
 	if err != nil {
 		return nil, err
 	}
@@ -190,20 +603,74 @@ func (t *translator) newHTTPListener(manager *envoyfilterhcmv3.HttpConnectionMan
 	}}
 
 	return &envoylistenerv3.Listener{
-		Name: fmt.Sprintf("listener_%d", *t.envoyListenPort),
-		Address: &envoycorev3.Address{
-			Address: &envoycorev3.Address_SocketAddress{
-				SocketAddress: &envoycorev3.SocketAddress{
-					Protocol: envoycorev3.SocketAddress_TCP,
-					Address:  "0.0.0.0",
-					PortSpecifier: &envoycorev3.SocketAddress_PortValue{
-						PortValue: uint32(*t.envoyListenPort),
-					},
-				},
-			},
-		},
+		Name:    fmt.Sprintf("listener_%d", *t.envoyListenPort),
+		Address: t.listenerAddress(uint32(*t.envoyListenPort)),
 		FilterChains: []*envoylistenerv3.FilterChain{
 			{Filters: filters},
 		},
 	}, nil
 }
+
+// newHTTPSListener returns a listener terminating TLS, with a filter chain per group of SNI hosts.
+// The certificates are fetched through SDS, so rotating them doesn't modify the listener.
+func (t *translator) newHTTPSListener(manager *envoyfilterhcmv3.HttpConnectionManager, chains []tlsFilterChain) (*envoylistenerv3.Listener, error) {
+	managerAny, err := marshalAny(manager)
+	if err != nil {
+		return nil, err
+	}
+
+	inspectorAny, err := marshalAny(&envoytlsinspectorv3.TlsInspector{})
+	if err != nil {
+		return nil, err
+	}
+
+	filterChains := make([]*envoylistenerv3.FilterChain, 0, len(chains))
+	for _, chain := range chains {
+		tlsContextAny, err := marshalAny(&envoytlsv3.DownstreamTlsContext{
+			CommonTlsContext: &envoytlsv3.CommonTlsContext{
+				AlpnProtocols: []string{"h2", "http/1.1"},
+				TlsCertificateSdsSecretConfigs: []*envoytlsv3.SdsSecretConfig{{
+					Name:      chain.secretName,
+					SdsConfig: adsConfigSource(),
+				}},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		filterChains = append(filterChains, &envoylistenerv3.FilterChain{
+			FilterChainMatch: &envoylistenerv3.FilterChainMatch{
+				ServerNames: chain.hosts,
+			},
+			Filters: []*envoylistenerv3.Filter{{
+				Name:       wellknown.HTTPConnectionManager,
+				ConfigType: &envoylistenerv3.Filter_TypedConfig{TypedConfig: managerAny},
+			}},
+			TransportSocket: &envoycorev3.TransportSocket{
+				Name:       wellknown.TransportSocketTls,
+				ConfigType: &envoycorev3.TransportSocket_TypedConfig{TypedConfig: tlsContextAny},
+			},
+		})
+	}
+
+	return &envoylistenerv3.Listener{
+		Name:    fmt.Sprintf("listener_%d", *t.envoyTLSListenPort),
+		Address: t.listenerAddress(uint32(*t.envoyTLSListenPort)),
+		ListenerFilters: []*envoylistenerv3.ListenerFilter{{
+			Name:       wellknown.TLSInspector,
+			ConfigType: &envoylistenerv3.ListenerFilter_TypedConfig{TypedConfig: inspectorAny},
+		}},
+		FilterChains: filterChains,
+	}, nil
+}
+
+func adsConfigSource() *envoycorev3.ConfigSource {
+	return &envoycorev3.ConfigSource{
+		ResourceApiVersion: resource.DefaultAPIVersion,
+		ConfigSourceSpecifier: &envoycorev3.ConfigSource_Ads{
+			Ads: &envoycorev3.AggregatedConfigSource{},
+		},
+		InitialFetchTimeout: durationpb.New(10 * time.Second),
+	}
+}
@@ -0,0 +1,110 @@
+package envoy
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// routeMatches returns true if Envoy matches the path of a request with the route match.
+func routeMatches(t *testing.T, match *envoyroutev3.RouteMatch, path string) bool {
+	switch specifier := match.PathSpecifier.(type) {
+	case *envoyroutev3.RouteMatch_Path:
+		return path == specifier.Path
+	case *envoyroutev3.RouteMatch_Prefix:
+		return strings.HasPrefix(path, specifier.Prefix)
+	case *envoyroutev3.RouteMatch_SafeRegex:
+		// Envoy matches the regular expressions against the full path.
+		return regexp.MustCompile("^(?:" + specifier.SafeRegex.Regex + ")$").MatchString(path)
+	}
+	t.Fatalf("unexpected path specifier %T", match.PathSpecifier)
+	return false
+}
+
+func TestNewRouteMatch(t *testing.T) {
+	exact, prefix, implementationSpecific := networkingv1.PathTypeExact, networkingv1.PathTypePrefix, networkingv1.PathTypeImplementationSpecific
+
+	// The examples of the Ingress specification, and the ImplementationSpecific paths.
+	tests := []struct {
+		pathType           *networkingv1.PathType
+		implementationType string
+		path               string
+		request            string
+		matches            bool
+	}{
+		{pathType: &prefix, path: "/", request: "/", matches: true},
+		{pathType: &prefix, path: "/", request: "/foo", matches: true},
+		{pathType: &exact, path: "/foo", request: "/foo", matches: true},
+		{pathType: &exact, path: "/foo", request: "/bar", matches: false},
+		{pathType: &exact, path: "/foo", request: "/foo/", matches: false},
+		{pathType: &exact, path: "/foo/", request: "/foo", matches: false},
+		{pathType: &prefix, path: "/foo", request: "/foo", matches: true},
+		{pathType: &prefix, path: "/foo", request: "/foo/", matches: true},
+		{pathType: &prefix, path: "/foo/", request: "/foo", matches: true},
+		{pathType: &prefix, path: "/foo/", request: "/foo/", matches: true},
+		{pathType: &prefix, path: "/foo", request: "/foobar", matches: false},
+		{pathType: &prefix, path: "/aaa/bb", request: "/aaa/bbb", matches: false},
+		{pathType: &prefix, path: "/aaa/bbb", request: "/aaa/bbb", matches: true},
+		{pathType: &prefix, path: "/aaa/bbb/", request: "/aaa/bbb", matches: true},
+		{pathType: &prefix, path: "/aaa/bbb", request: "/aaa/bbb/", matches: true},
+		{pathType: &prefix, path: "/aaa/bbb", request: "/aaa/bbb/ccc", matches: true},
+		{pathType: &prefix, path: "/aaa/bbb", request: "/aaa/bbbxyz", matches: false},
+		{pathType: &prefix, path: "/a.b", request: "/axb", matches: false},
+		{pathType: &implementationSpecific, implementationType: PathTypePrefix, path: "/foo", request: "/foobar", matches: true},
+		{pathType: &implementationSpecific, implementationType: PathTypePrefix, path: "/foo", request: "/bar", matches: false},
+		{pathType: nil, implementationType: PathTypePrefix, path: "/foo", request: "/foo/bar", matches: true},
+		{pathType: &implementationSpecific, implementationType: PathTypeRegex, path: "/foo/[0-9]+", request: "/foo/12", matches: true},
+		{pathType: &implementationSpecific, implementationType: PathTypeRegex, path: "/foo/[0-9]+", request: "/foo/12/bar", matches: false},
+		{pathType: &implementationSpecific, implementationType: PathTypeRegex, path: "/foo|/bar", request: "/bar", matches: true},
+	}
+
+	for _, test := range tests {
+		tr := &translator{implementationSpecificPathType: PathTypePrefix}
+		if test.implementationType != "" {
+			tr.implementationSpecificPathType = test.implementationType
+		}
+		path := networkingv1.HTTPIngressPath{Path: test.path, PathType: test.pathType}
+		typ := implementationSpecific
+		if test.pathType != nil {
+			typ = *test.pathType
+		}
+		match, err := tr.newRouteMatch(path)
+		if err != nil {
+			t.Fatalf("unexpected error for path %q: %v", test.path, err)
+		}
+		if matches := routeMatches(t, match, test.request); matches != test.matches {
+			t.Errorf("path %q of type %s matching %q: expected %t, got %t", test.path, typ, test.request, test.matches, matches)
+		}
+	}
+}
+
+func TestNewRouteMatchErrors(t *testing.T) {
+	implementationSpecific, unknown := networkingv1.PathTypeImplementationSpecific, networkingv1.PathType("Unknown")
+
+	tr := &translator{implementationSpecificPathType: PathTypeRegex}
+	if _, err := tr.newRouteMatch(networkingv1.HTTPIngressPath{Path: "/foo(", PathType: &implementationSpecific}); err == nil {
+		t.Errorf("expected an error for an invalid regular expression")
+	}
+	if _, err := tr.newRouteMatch(networkingv1.HTTPIngressPath{Path: "/foo", PathType: &unknown}); err == nil {
+		t.Errorf("expected an error for an unknown path type")
+	}
+}
+
+func TestNewWildcardHostMatcher(t *testing.T) {
+	regex := regexp.MustCompile("^(?:" + newWildcardHostMatcher("*.foo.com").GetSafeRegexMatch().Regex + ")$")
+	for authority, matches := range map[string]bool{
+		"bar.foo.com":      true,
+		"BAR.Foo.com":      true,
+		"bar.foo.com:8443": true,
+		"foo.com":          false,
+		"baz.bar.foo.com":  false,
+		"barxfoo.com":      false,
+	} {
+		if regex.MatchString(authority) != matches {
+			t.Errorf("expected %q matching *.foo.com to be %t", authority, matches)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package envoy
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoycorev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoytlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"google.golang.org/protobuf/types/known/structpb"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// transportSocketMatchKey is the endpoint metadata filter matched by the transport socket matches of a cluster.
+	transportSocketMatchKey = "envoy.transport_socket_match"
+	// upstreamTLSMatch is the name of the transport socket match of the endpoints serving HTTPS.
+	upstreamTLSMatch = "tls"
+	// systemCABundle is the CA bundle of the Envoy image, trusted when no upstream CA is configured.
+	systemCABundle = "/etc/ssl/certs/ca-certificates.crt"
+)
+
+// UpstreamTLSConfig configures how the certificates of the cluster gateways serving HTTPS are verified.
+type UpstreamTLSConfig struct {
+	// CA is a PEM bundle of the CAs trusted to sign the certificates. The CAs of the Envoy image are trusted when empty.
+	CA []byte
+	// InsecureSkipVerify disables the verification of the certificates.
+	InsecureSkipVerify bool
+}
+
+// Validate returns an error if the configuration can't be translated.
+func (c UpstreamTLSConfig) Validate() error {
+	if len(c.CA) == 0 {
+		return nil
+	}
+	if c.InsecureSkipVerify {
+		return fmt.Errorf("an upstream CA can't be set when skipping the verification of the upstream certificates")
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(c.CA) {
+		return fmt.Errorf("the upstream CA bundle doesn't hold any PEM certificate")
+	}
+	return nil
+}
+
+// upstreamPort returns the port requests are sent to on a cluster gateway, from the ports of its load balancer status,
+// and whether TLS has to be originated to it:
+//   - The HTTP port, if the gateway exposes it.
+//   - Otherwise the HTTPS port with TLS, if the gateway only exposes HTTPS.
+//   - Otherwise the first exposed TCP port.
+//
+// Gateways without port status are expected to listen on the HTTP port.
+// With the BackendProtocolH2 protocol, the HTTPS port is preferred and TLS is always originated.
+func (t *translator) upstreamPort(lb v1.LoadBalancerIngress, protocol string) (uint32, bool) {
+	ports := make([]uint32, 0, len(lb.Ports))
+	for _, port := range lb.Ports {
+		if port.Error != nil || (port.Protocol != "" && port.Protocol != v1.ProtocolTCP) {
+			continue
+		}
+		ports = append(ports, uint32(port.Port))
+	}
+
+	if protocol == BackendProtocolH2 {
+		for _, port := range ports {
+			if port != t.upstreamTLSPort {
+				continue
+			}
+			return port, true
+		}
+		if len(ports) == 0 {
+			return t.upstreamTLSPort, true
+		}
+		return ports[0], true
+	}
+
+	if len(ports) == 0 {
+		return t.upstreamPlainPort, false
+	}
+	for _, port := range ports {
+		if port == t.upstreamPlainPort {
+			return port, false
+		}
+	}
+	for _, port := range ports {
+		if port == t.upstreamTLSPort {
+			return port, true
+		}
+	}
+	return ports[0], false
+}
+
+// upstreamTLSMetadata marks an endpoint as serving HTTPS, so it's matched by the TLS transport socket of its cluster.
+func upstreamTLSMetadata() *envoycorev3.Metadata {
+	return &envoycorev3.Metadata{
+		FilterMetadata: map[string]*structpb.Struct{
+			transportSocketMatchKey: {Fields: map[string]*structpb.Value{
+				upstreamTLSMatch: structpb.NewBoolValue(true),
+			}},
+		},
+	}
+}
+
+// hasUpstreamTLS returns whether any endpoint of the cluster serves HTTPS.
+func hasUpstreamTLS(cluster *envoyclusterv3.Cluster) bool {
+	for _, locality := range cluster.GetLoadAssignment().GetEndpoints() {
+		for _, endpoint := range locality.LbEndpoints {
+			if endpoint.Metadata != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setUpstreamTLS originates TLS to the endpoints of the cluster serving HTTPS, negotiating the given ALPN protocols,
+// while the other endpoints keep getting plain HTTP. The certificates of the cluster gateways are verified against the
+// upstream CAs, unless verification is disabled, and their names are matched with the SNI by setUpstreamProtocol.
+func (t *translator) setUpstreamTLS(cluster *envoyclusterv3.Cluster, sni string, alpn []string) error {
+	tlsContext := &envoytlsv3.UpstreamTlsContext{
+		CommonTlsContext: &envoytlsv3.CommonTlsContext{
+			AlpnProtocols: alpn,
+		},
+		Sni: sni,
+	}
+	if !t.upstreamTLS.InsecureSkipVerify {
+		trustedCA := &envoycorev3.DataSource{Specifier: &envoycorev3.DataSource_Filename{Filename: systemCABundle}}
+		if len(t.upstreamTLS.CA) > 0 {
+			trustedCA.Specifier = &envoycorev3.DataSource_InlineBytes{InlineBytes: t.upstreamTLS.CA}
+		}
+		tlsContext.CommonTlsContext.ValidationContextType = &envoytlsv3.CommonTlsContext_ValidationContext{
+			ValidationContext: &envoytlsv3.CertificateValidationContext{TrustedCa: trustedCA},
+		}
+	}
+
+	tlsContextAny, err := marshalAny(tlsContext)
+	if err != nil {
+		return err
+	}
+
+	cluster.TransportSocketMatches = []*envoyclusterv3.Cluster_TransportSocketMatch{{
+		Name: upstreamTLSMatch,
+		Match: &structpb.Struct{Fields: map[string]*structpb.Value{
+			upstreamTLSMatch: structpb.NewBoolValue(true),
+		}},
+		TransportSocket: &envoycorev3.TransportSocket{
+			Name:       wellknown.TransportSocketTls,
+			ConfigType: &envoycorev3.TransportSocket_TypedConfig{TypedConfig: tlsContextAny},
+		},
+	}}
+	return nil
+}
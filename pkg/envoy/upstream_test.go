@@ -0,0 +1,80 @@
+package envoy
+
+import (
+	"testing"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoyendpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoytlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	envoyupstreamhttpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+)
+
+const testCA = `-----BEGIN CERTIFICATE-----
+MIIBejCCAR+gAwIBAgIUVwlmQUY+k89fLv4OiB/JSS29DZAwCgYIKoZIzj0EAwIw
+EjEQMA4GA1UEAwwHdGVzdC1jYTAeFw0yNjEwMTgxNzM2MzJaFw0zNjEwMTUxNzM2
+MzJaMBIxEDAOBgNVBAMMB3Rlc3QtY2EwWTATBgcqhkjOPQIBBggqhkjOPQMBBwNC
+AAQR83mj50azsJ9cLK8ijkV7rdjjnjChxOY6Z1f6KsfF6DMoyjCt4Bqa91HPdJ+f
+5+l2r1vsKSWEdYn56cDsQxeyo1MwUTAdBgNVHQ4EFgQUG6iz7iB2Jzwfd6fRn7kg
+e4ch4YowHwYDVR0jBBgwFoAUG6iz7iB2Jzwfd6fRn7kge4ch4YowDwYDVR0TAQH/
+BAUwAwEB/zAKBggqhkjOPQQDAgNJADBGAiEA+lLVnmZH/G8VAUaJ71jDv1EOTVEe
+9dlKGsJfenExpY4CIQCa2s7r7QzzmrAdmJNl6ddTcZezUFkFg1uwsrUJJJjeKQ==
+-----END CERTIFICATE-----
+`
+
+func TestSetUpstreamTLS(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		config    UpstreamTLSConfig
+		trustedCA string
+	}{
+		{name: "system CAs", config: UpstreamTLSConfig{}, trustedCA: systemCABundle},
+		{name: "upstream CA", config: UpstreamTLSConfig{CA: []byte(testCA)}, trustedCA: testCA},
+		{name: "insecure", config: UpstreamTLSConfig{InsecureSkipVerify: true}},
+	} {
+		tr := newTestTranslator()
+		tr.upstreamTLS = test.config
+		cluster := &envoyclusterv3.Cluster{LoadAssignment: &envoyendpointv3.ClusterLoadAssignment{
+			Endpoints: []*envoyendpointv3.LocalityLbEndpoints{{
+				LbEndpoints: []*envoyendpointv3.LbEndpoint{tr.newLBEndpoint("10.0.0.1", 443, true)},
+			}},
+		}}
+		if err := tr.setUpstreamProtocol(cluster, BackendProtocolHTTP, "foo.com"); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+
+		tlsContext := &envoytlsv3.UpstreamTlsContext{}
+		if err := cluster.TransportSocketMatches[0].TransportSocket.GetTypedConfig().UnmarshalTo(tlsContext); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		trustedCA := tlsContext.CommonTlsContext.GetValidationContext().GetTrustedCa()
+		if trustedCA.GetFilename()+string(trustedCA.GetInlineBytes()) != test.trustedCA {
+			t.Errorf("%s: expected the trusted CAs %q, got %v", test.name, test.trustedCA, trustedCA)
+		}
+
+		options := &envoyupstreamhttpv3.HttpProtocolOptions{}
+		if err := cluster.TypedExtensionProtocolOptions[httpProtocolOptionsName].UnmarshalTo(options); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		// The certificates are valid for the SNI of each request.
+		if sanValidation := options.UpstreamHttpProtocolOptions.AutoSanValidation; sanValidation == test.config.InsecureSkipVerify {
+			t.Errorf("%s: unexpected SAN validation %t", test.name, sanValidation)
+		}
+	}
+}
+
+func TestUpstreamTLSConfigValidate(t *testing.T) {
+	for _, test := range []struct {
+		config UpstreamTLSConfig
+		valid  bool
+	}{
+		{config: UpstreamTLSConfig{}, valid: true},
+		{config: UpstreamTLSConfig{InsecureSkipVerify: true}, valid: true},
+		{config: UpstreamTLSConfig{CA: []byte(testCA)}, valid: true},
+		{config: UpstreamTLSConfig{CA: []byte("not a certificate")}, valid: false},
+		{config: UpstreamTLSConfig{CA: []byte(testCA), InsecureSkipVerify: true}, valid: false},
+	} {
+		if err := test.config.Validate(); (err == nil) != test.valid {
+			t.Errorf("unexpected validation of %+v: %v", test.config, err)
+		}
+	}
+}
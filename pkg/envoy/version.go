@@ -0,0 +1,86 @@
+package envoy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoycachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// resourceVersion returns a version of the resource that only changes with its content.
+func resourceVersion(r cachetypes.Resource) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(b)
+	return hex.EncodeToString(hash[:])[:16], nil
+}
+
+// resourceVersions returns the versions of the resources by name. The resources that can't be hashed are left out, so
+// they are hashed again by newVersionedSnapshot.
+func resourceVersions(resources []cachetypes.Resource) map[string]string {
+	versions := make(map[string]string, len(resources))
+	for _, r := range resources {
+		if version, err := resourceVersion(r); err == nil {
+			versions[envoycachev3.GetResourceName(r)] = version
+		}
+	}
+	return versions
+}
+
+// newVersionedSnapshot returns a snapshot where each resource is versioned by its content, and each type of resources
+// by the names and versions of its resources, along with the versions by type. Envoy is only sent the types of
+// resources that changed, and with delta xDS, only the resources that changed. The resources whose version is known,
+// by type and name, are not hashed again.
+func newVersionedSnapshot(resources map[resource.Type][]cachetypes.Resource, known map[resource.Type]map[string]string) (envoycachev3.Snapshot, map[resource.Type]string, error) {
+	// The version map is set, so the snapshot cache doesn't build it again with its non-deterministic marshaling.
+	snapshot := envoycachev3.Snapshot{VersionMap: make(map[string]map[string]string, len(resources))}
+	versions := make(map[resource.Type]string, len(resources))
+	for typ, items := range resources {
+		itemVersions := make(map[string]string, len(items))
+		hash := sha256.New()
+		for _, r := range items {
+			name := envoycachev3.GetResourceName(r)
+			version, ok := known[typ][name]
+			if !ok {
+				var err error
+				if version, err = resourceVersion(r); err != nil {
+					return snapshot, nil, err
+				}
+			}
+			itemVersions[name] = version
+			hash.Write([]byte(name + "=" + version + "\n"))
+		}
+		versions[typ] = hex.EncodeToString(hash.Sum(nil))[:16]
+		snapshot.VersionMap[typ] = itemVersions
+		snapshot.Resources[envoycachev3.GetResponseType(typ)] = envoycachev3.NewResources(versions[typ], items)
+	}
+	return snapshot, versions, nil
+}
+
+// sameVersions returns whether two snapshots have the same versions for all the types of resources.
+func sameVersions(a, b map[resource.Type]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for typ, version := range a {
+		if b[typ] != version {
+			return false
+		}
+	}
+	return true
+}
+
+// marshalAny wraps the message in an Any, marshaled deterministically so its content hash is stable.
+func marshalAny(m proto.Message) (*anypb.Any, error) {
+	a := &anypb.Any{}
+	if err := anypb.MarshalFrom(a, m, proto.MarshalOptions{Deterministic: true}); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
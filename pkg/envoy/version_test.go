@@ -0,0 +1,51 @@
+package envoy
+
+import (
+	"testing"
+	"time"
+
+	envoyclusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	cachetypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestNewVersionedSnapshot(t *testing.T) {
+	clusters := func(timeout time.Duration) map[resource.Type][]cachetypes.Resource {
+		return map[resource.Type][]cachetypes.Resource{resource.ClusterType: {
+			&envoyclusterv3.Cluster{Name: "a", ConnectTimeout: durationpb.New(1)},
+			&envoyclusterv3.Cluster{Name: "b", ConnectTimeout: durationpb.New(timeout)},
+		}}
+	}
+
+	first, firstVersions, err := newVersionedSnapshot(clusters(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, sameVersionsByType, err := newVersionedSnapshot(clusters(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, changedVersions, err := newVersionedSnapshot(clusters(2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sameVersions(firstVersions, sameVersionsByType) {
+		t.Errorf("expected the same versions for the same content, got %v and %v", firstVersions, sameVersionsByType)
+	}
+	if sameVersions(firstVersions, changedVersions) {
+		t.Errorf("expected different versions for different content, got %v", firstVersions)
+	}
+	if first.GetVersion(resource.ClusterType) != same.GetVersion(resource.ClusterType) {
+		t.Errorf("expected the same snapshot versions for the same content")
+	}
+
+	a, b := first.GetVersionMap(resource.ClusterType), changed.GetVersionMap(resource.ClusterType)
+	if a["a"] == "" || a["a"] != b["a"] {
+		t.Errorf("expected the unchanged resource to keep its version, got %q and %q", a["a"], b["a"])
+	}
+	if a["b"] == b["b"] {
+		t.Errorf("expected the changed resource to get a new version, got %q", a["b"])
+	}
+}
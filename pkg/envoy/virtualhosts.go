@@ -0,0 +1,177 @@
+package envoy
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// catchAllHost is the host of the virtual host getting the requests for any host not claimed by other virtual hosts.
+const catchAllHost = "*"
+
+// hostRoute is the route of an Ingress path, or its default backend, for a given host.
+type hostRoute struct {
+	host           string
+	path           networkingv1.HTTPIngressPath
+	route          *envoyroutev3.Route
+	ingress        networkingv1.Ingress
+	defaultBackend bool
+	// hostRateLimit is the local rate limit shared by all the routes of the host, if any.
+	hostRateLimit *anypb.Any
+	// httpsRedirect is set when the plaintext requests of the route are redirected to HTTPS.
+	httpsRedirect bool
+}
+
+// newVirtualHosts merges the routes of all the Ingresses into a virtual host per host, as Envoy rejects the whole route
+// configuration if two virtual hosts share a domain.
+//
+// When more than one Ingress claims the same host, path and path type, the oldest Ingress wins. Ingresses created at the
+// same time are ordered by key. The routes of the losing Ingresses are dropped and returned as warnings. Prefix paths
+// claim their path without the trailing "/".
+//
+// Routes within a virtual host are sorted by path length, longest first, and Exact paths before the other types with the same
+// length, as Envoy picks the first route that matches. The default backend goes last.
+//
+// A rate limit shared by the routes of a host is only set on the virtual host when all its routes come from the Ingress
+// setting it, otherwise the routes of the Ingress are rate limited each on their own. External authorization is
+// disabled on the virtual hosts, and enabled by the routes of the Ingresses asking for it.
+//
+// Envoy selects the virtual host before the route, by exact domains first, then wildcard domains, and then the catch-all
+// virtual host, so a host claimed by a rule is never served by host-less rules, nor by the default backends of the
+// Ingresses not claiming it.
+func (t *translator) newVirtualHosts(routes []hostRoute) ([]*envoyroutev3.VirtualHost, []Warning) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		a, b := routes[i].ingress, routes[j].ingress
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return ingressToKey(a) < ingressToKey(b)
+	})
+
+	type claim struct {
+		host           string
+		path           string
+		pathType       networkingv1.PathType
+		defaultBackend bool
+	}
+	claims := map[claim]string{}
+	warnings := make([]Warning, 0)
+
+	hosts := make([]string, 0)
+	hostRoutes := map[string][]hostRoute{}
+
+	for _, r := range routes {
+		key := claim{host: r.host, path: claimedPath(r.path), pathType: pathType(r.path), defaultBackend: r.defaultBackend}
+		if winner, ok := claims[key]; ok {
+			if winner == ingressToKey(r.ingress) {
+				continue
+			}
+			if r.defaultBackend {
+				warnings = append(warnings, newWarning(r.ingress, ReasonHostConflict, "default backend of host %q is already claimed by Ingress %q", r.host, winner))
+			} else {
+				warnings = append(warnings, newWarning(r.ingress, ReasonHostConflict, "host %q and path %q are already claimed by Ingress %q", r.host, r.path.Path, winner))
+			}
+			continue
+		}
+		claims[key] = ingressToKey(r.ingress)
+
+		if _, ok := hostRoutes[r.host]; !ok {
+			hosts = append(hosts, r.host)
+		}
+		hostRoutes[r.host] = append(hostRoutes[r.host], r)
+	}
+	sort.Strings(hosts)
+
+	extAuthz, err := t.extAuthzVirtualHostConfig()
+	if err != nil {
+		log.Printf("failed to configure the external authorization of the virtual hosts: %v", err)
+	}
+
+	virtualHosts := make([]*envoyroutev3.VirtualHost, 0, len(hosts))
+	for _, host := range hosts {
+		hr := hostRoutes[host]
+
+		filterConfig := map[string]*anypb.Any{}
+		for filter, config := range extAuthz {
+			filterConfig[filter] = config
+		}
+		if rateLimit := hostRateLimit(hr); rateLimit != nil {
+			filterConfig[localRateLimitFilter] = rateLimit
+		} else {
+			for i, r := range hr {
+				if r.hostRateLimit == nil {
+					continue
+				}
+				warnings = append(warnings, newWarning(r.ingress, ReasonHostConflict, "host %q is shared with other Ingresses, rate limiting each route on its own", host))
+				// The routes are cached, and the host may not be shared anymore in the next snapshots.
+				hr[i].route = proto.Clone(r.route).(*envoyroutev3.Route)
+				setRouteRateLimit(hr[i].route, r.hostRateLimit)
+			}
+		}
+		if len(filterConfig) == 0 {
+			filterConfig = nil
+		}
+
+		sort.SliceStable(hr, func(i, j int) bool {
+			return routePrecedes(hr[i], hr[j])
+		})
+
+		vhRoutes := make([]*envoyroutev3.Route, 0, len(hr))
+		for _, r := range hr {
+			vhRoutes = append(vhRoutes, r.route)
+		}
+
+		virtualHosts = append(virtualHosts, &envoyroutev3.VirtualHost{
+			Name:                 host,
+			Domains:              []string{host},
+			Routes:               vhRoutes,
+			TypedPerFilterConfig: filterConfig,
+		})
+	}
+
+	return virtualHosts, warnings
+}
+
+// hostRateLimit returns the rate limit shared by all the routes of a host, if any, when they all come from the Ingress
+// setting it.
+func hostRateLimit(routes []hostRoute) *anypb.Any {
+	for _, r := range routes {
+		if ingressToKey(r.ingress) != ingressToKey(routes[0].ingress) {
+			return nil
+		}
+	}
+	return routes[0].hostRateLimit
+}
+
+// claimedPath returns the path claimed by an Ingress path. Prefix paths ignore the trailing "/", so "/foo" and "/foo/"
+// claim the same path.
+func claimedPath(path networkingv1.HTTPIngressPath) string {
+	if pathType(path) == networkingv1.PathTypePrefix {
+		return strings.TrimRight(path.Path, "/")
+	}
+	return path.Path
+}
+
+// routePrecedes returns true if the route a has to be evaluated before the route b.
+func routePrecedes(a, b hostRoute) bool {
+	if a.defaultBackend != b.defaultBackend {
+		return b.defaultBackend
+	}
+	la, lb := len(strings.TrimRight(a.path.Path, "/")), len(strings.TrimRight(b.path.Path, "/"))
+	if la != lb {
+		return la > lb
+	}
+	return pathType(a.path) == networkingv1.PathTypeExact && pathType(b.path) != networkingv1.PathTypeExact
+}
+
+func pathType(path networkingv1.HTTPIngressPath) networkingv1.PathType {
+	if path.PathType == nil {
+		return networkingv1.PathTypeImplementationSpecific
+	}
+	return *path.PathType
+}
@@ -0,0 +1,136 @@
+package envoy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	envoyroutev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// translateRoutes returns the routes of the Ingresses, created in the given order.
+func translateRoutes(t *translator, ingresses ...networkingv1.Ingress) []hostRoute {
+	routes := make([]hostRoute, 0)
+	for i, ingress := range ingresses {
+		ingress.CreationTimestamp = metav1.NewTime(time.Unix(int64(i), 0))
+		_, _, ingressRoutes, _ := t.translateIngress(ingress, testLeaves, nil)
+		routes = append(routes, ingressRoutes...)
+	}
+	return routes
+}
+
+func virtualHost(virtualHosts []*envoyroutev3.VirtualHost, name string) *envoyroutev3.VirtualHost {
+	for _, vh := range virtualHosts {
+		if vh.Name == name {
+			return vh
+		}
+	}
+	return nil
+}
+
+func TestHostRateLimit(t *testing.T) {
+	tr := newTestTranslator()
+	limited := newTestIngress("limited", "foo.com", "bar.com")
+	limited.Annotations[RateLimitRequestsAnnotation] = "10"
+	limited.Annotations[RateLimitScopeAnnotation] = RateLimitScopeHost
+	other := newTestIngress("other", "bar.com")
+	other.Spec.Rules[0].HTTP.Paths[0].Path = "/other"
+
+	virtualHosts, warnings := tr.newVirtualHosts(translateRoutes(tr, limited, other))
+
+	if vh := virtualHost(virtualHosts, "foo.com"); vh.TypedPerFilterConfig[localRateLimitFilter] == nil {
+		t.Errorf("expected the host of a single Ingress to be rate limited")
+	}
+
+	vh := virtualHost(virtualHosts, "bar.com")
+	if vh.TypedPerFilterConfig[localRateLimitFilter] != nil {
+		t.Errorf("expected the shared host not to be rate limited")
+	}
+	for _, route := range vh.Routes {
+		limitedRoute := route.TypedPerFilterConfig[localRateLimitFilter] != nil
+		if ownRoute := strings.HasPrefix(route.Name, "limiteddefault"); limitedRoute != ownRoute {
+			t.Errorf("expected only the routes of the Ingress to be rate limited, route %q limited: %t", route.Name, limitedRoute)
+		}
+	}
+	if len(warnings) != 1 || warnings[0].Ingress.Name != "limited" {
+		t.Errorf("expected a warning on the rate limited Ingress, got %v", warnings)
+	}
+}
+
+func TestNewVirtualHosts(t *testing.T) {
+	exact := networkingv1.PathTypeExact
+	tr := newTestTranslator()
+
+	older := newTestIngress("older", "foo.com", "foo.com", "foo.com", "bar.com")
+	older.Spec.Rules[1].HTTP.Paths[0].Path = "/api"
+	older.Spec.Rules[2].HTTP.Paths[0].Path = "/api"
+	older.Spec.Rules[2].HTTP.Paths[0].PathType = &exact
+	newer := newTestIngress("newer", "foo.com", "foo.com")
+	newer.Spec.Rules[0].HTTP.Paths[0].Path = "/api/"
+	newer.Spec.Rules[1].HTTP.Paths[0].Path = "/api/v1"
+	newer.Spec.Rules[1].HTTP.Paths[0].PathType = &exact
+
+	virtualHosts, warnings := tr.newVirtualHosts(translateRoutes(tr, older, newer))
+
+	names := make([]string, 0)
+	for _, vh := range virtualHosts {
+		names = append(names, vh.Name)
+	}
+	if strings.Join(names, ",") != "bar.com,foo.com" {
+		t.Errorf("expected a virtual host per host, got %v", names)
+	}
+
+	// Longest paths first, Exact paths before Prefix paths of the same length.
+	routes := make([]string, 0)
+	for _, route := range virtualHost(virtualHosts, "foo.com").Routes {
+		routes = append(routes, route.Name)
+	}
+	if expected := "newerdefault1,olderdefault2,olderdefault1,olderdefault0"; strings.Join(routes, ",") != expected {
+		t.Errorf("expected the routes %s, got %s", expected, strings.Join(routes, ","))
+	}
+
+	// The trailing slash doesn't change the path claimed by a Prefix path.
+	if len(warnings) != 1 || warnings[0].Ingress.Name != "newer" || warnings[0].Reason != ReasonHostConflict {
+		t.Errorf("expected a host conflict warning on the newer Ingress, got %v", warnings)
+	}
+}
+
+func TestDefaultBackendVirtualHosts(t *testing.T) {
+	tr := newTestTranslator()
+	backend := &networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "web", Port: networkingv1.ServiceBackendPort{Number: 80}}}
+
+	older := newTestIngress("older", "foo.com", "*.bar.com")
+	older.Spec.Rules[0].HTTP.Paths[0].Path = "/api"
+	older.Spec.DefaultBackend = backend
+	newer := newTestIngress("newer", "foo.com")
+	newer.Spec.Rules[0].HTTP.Paths[0].Path = "/web"
+	newer.Spec.DefaultBackend = backend
+
+	virtualHosts, warnings := tr.newVirtualHosts(translateRoutes(tr, older, newer))
+
+	// The default backend goes last on each host of the Ingress.
+	for host, expected := range map[string]string{
+		"foo.com":   "olderdefault0,newerdefault0,olderdefaultdefault",
+		"*.bar.com": "olderdefault1,olderdefaultdefault",
+		"*":         "olderdefaultdefault",
+	} {
+		routes := make([]string, 0)
+		for _, route := range virtualHost(virtualHosts, host).Routes {
+			routes = append(routes, route.Name)
+		}
+		if strings.Join(routes, ",") != expected {
+			t.Errorf("expected the routes %s on host %q, got %s", expected, host, strings.Join(routes, ","))
+		}
+	}
+
+	wildcardRoutes := virtualHost(virtualHosts, "*.bar.com").Routes
+	if headers := wildcardRoutes[len(wildcardRoutes)-1].Match.Headers; len(headers) != 1 {
+		t.Errorf("expected the default backend of a wildcard host to match a single DNS label, got %v", headers)
+	}
+
+	if len(warnings) != 2 || warnings[0].Ingress.Name != "newer" || warnings[1].Ingress.Name != "newer" {
+		t.Errorf("expected the default backends of the newer Ingress to conflict, got %v", warnings)
+	}
+}
@@ -0,0 +1,47 @@
+package envoy
+
+import (
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// Reasons of the warnings.
+const (
+	ReasonHostConflict           = "HostConflict"
+	ReasonInvalidAnnotation      = "InvalidAnnotation"
+	ReasonInvalidPath            = "InvalidPath"
+	ReasonMissingHealthCheckHost = "MissingHealthCheckHost"
+)
+
+// Warning is a problem found when translating an Ingress. The rest of the Ingress is still served.
+type Warning struct {
+	Ingress networkingv1.Ingress
+	// Reason is a short CamelCase reason, like ReasonHostConflict.
+	Reason  string
+	Message string
+}
+
+// IngressWarnings are the current warnings of an Ingress, to be listed in its WarningsAnnotation.
+type IngressWarnings struct {
+	Ingress  networkingv1.Ingress
+	Warnings []Warning
+}
+
+func newWarning(ingress networkingv1.Ingress, reason, format string, args ...interface{}) Warning {
+	return Warning{
+		Ingress: ingress,
+		Reason:  reason,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// FormatWarnings formats the warnings of an Ingress as the value of its WarningsAnnotation.
+func FormatWarnings(warnings []Warning) string {
+	lines := make([]string, 0, len(warnings))
+	for _, warning := range warnings {
+		lines = append(lines, warning.Reason+": "+warning.Message)
+	}
+	return strings.Join(lines, "\n")
+}
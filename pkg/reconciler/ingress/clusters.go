@@ -0,0 +1,70 @@
+package ingress
+
+import (
+	"fmt"
+
+	"github.com/jmprusi/kcp-ingress/pkg/envoy"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// clusterResource is the kcp Cluster the leaves are synced to. Its topology labels are used to place the leaves in
+// Envoy localities.
+var clusterResource = schema.GroupVersionResource{Group: "cluster.example.dev", Version: "v1alpha1", Resource: "clusters"}
+
+// envoyLeaf returns the Envoy view of a leaf, with the topology of its cluster.
+func (c *Controller) envoyLeaf(leaf *networkingv1.Ingress) (envoy.Leaf, error) {
+	result := envoy.Leaf{
+		Cluster:      leaf.Labels[clusterLabel],
+		LoadBalancer: leaf.Status.LoadBalancer.Ingress,
+	}
+
+	cluster := &unstructured.Unstructured{}
+	cluster.SetName(result.Cluster)
+	cluster.SetClusterName(leaf.ClusterName)
+	clusterIf, exists, err := c.clusterIndexer.Get(cluster)
+	if err != nil {
+		return result, err
+	}
+	if exists {
+		clusterLabels := clusterIf.(*unstructured.Unstructured).GetLabels()
+		result.Region = clusterLabels[v1.LabelTopologyRegion]
+		result.Zone = clusterLabels[v1.LabelTopologyZone]
+	}
+	return result, nil
+}
+
+// ingressesFromCluster enqueues all the leaves synced to a given cluster, so their locality gets updated.
+func (c *Controller) ingressesFromCluster(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	cluster, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	sel, err := labels.Parse(fmt.Sprintf("%s=%s", clusterLabel, cluster.GetName()))
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	leaves, err := c.lister.List(sel)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, leaf := range leaves {
+		if leaf.ClusterName != cluster.GetClusterName() {
+			continue
+		}
+		klog.Infof("cluster %q triggered Ingress %q reconciliation", cluster.GetName(), leaf.Name)
+		c.enqueue(leaf)
+	}
+}
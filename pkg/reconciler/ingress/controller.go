@@ -2,6 +2,7 @@ package ingress
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/jmprusi/kcp-ingress/pkg/envoy"
@@ -9,16 +10,24 @@ import (
 	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corev1lister "k8s.io/client-go/listers/core/v1"
 	networkingv1lister "k8s.io/client-go/listers/networking/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clusters"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
-	envoyserver "knative.dev/net-kourier/pkg/envoy/server"
 )
 
 const resyncPeriod = 10 * time.Hour
@@ -32,17 +41,20 @@ func NewController(config *ControllerConfig) *Controller {
 	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 	stopCh := make(chan struct{}) // TODO: hook this up to SIGTERM/SIGINT
 
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+
 	c := &Controller{
-		queue:   queue,
-		client:  client,
-		stopCh:  stopCh,
-		domain:  config.Domain,
-		tracker: *NewTracker(),
+		queue:    queue,
+		client:   client,
+		stopCh:   stopCh,
+		domain:   config.Domain,
+		recorder: broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "kcp-ingress"}),
 	}
 
 	if config.EnvoyXDS != nil {
 		c.envoyXDS = config.EnvoyXDS
-		c.cache = envoy.NewCache(envoy.NewTranslator(config.EnvoyListenPort))
+		c.cache = envoy.NewCache(envoy.NewTranslator(config.EnvoyTranslator), config.EnvoyFleets)
 
 		go func() {
 			err := c.envoyXDS.RunManagementServer()
@@ -54,19 +66,59 @@ func NewController(config *ControllerConfig) *Controller {
 
 	sif := informers.NewSharedInformerFactoryWithOptions(c.client, resyncPeriod)
 
+	// Index root Ingresses by the Services and TLS Secrets they reference, so their events can be mapped back to them.
+	if err := sif.Networking().V1().Ingresses().Informer().AddIndexers(cache.Indexers{
+		serviceIndex: indexByService,
+		secretIndex:  indexBySecret,
+	}); err != nil {
+		klog.Fatalf("Failed to add indexers: %v", err)
+	}
+
 	// Watch for events related to Ingresses
 	sif.Networking().V1().Ingresses().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    func(obj interface{}) { c.enqueue(obj) },
-		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+		AddFunc: func(obj interface{}) { c.enqueue(obj) },
+		UpdateFunc: func(old, obj interface{}) {
+			// The WarningsAnnotation is set by the controller itself after reconciling the Ingress.
+			if !onlyWarningsChanged(old.(*networkingv1.Ingress), obj.(*networkingv1.Ingress)) {
+				c.enqueue(obj)
+			}
+		},
 		DeleteFunc: func(obj interface{}) { c.enqueue(obj) },
 	})
 
 	// Watch for events related to Services
 	sif.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.ingressesFromService(obj) },
 		UpdateFunc: func(_, obj interface{}) { c.ingressesFromService(obj) },
 		DeleteFunc: func(obj interface{}) { c.ingressesFromService(obj) },
 	})
 
+	// Watch for events related to Endpoints, so clusters without ready backends can be taken out of rotation.
+	sif.Core().V1().Endpoints().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { c.ingressesFromEndpoints(obj) },
+		UpdateFunc: func(old, obj interface{}) {
+			// Endpoints are updated often, only the readiness matters to us.
+			if hasReadyEndpoints(old.(*v1.Endpoints)) != hasReadyEndpoints(obj.(*v1.Endpoints)) {
+				c.ingressesFromEndpoints(obj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) { c.ingressesFromEndpoints(obj) },
+	})
+
+	// Watch for events related to Secrets, so rotated certificates get copied to the clusters.
+	sif.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.ingressesFromSecret(obj) },
+		UpdateFunc: func(_, obj interface{}) { c.ingressesFromSecret(obj) },
+		DeleteFunc: func(obj interface{}) { c.ingressesFromSecret(obj) },
+	})
+
+	c.indexer = sif.Networking().V1().Ingresses().Informer().GetIndexer()
+	c.lister = sif.Networking().V1().Ingresses().Lister()
+	c.serviceIndexer = sif.Core().V1().Services().Informer().GetIndexer()
+	c.endpointsIndexer = sif.Core().V1().Endpoints().Informer().GetIndexer()
+	c.secretIndexer = sif.Core().V1().Secrets().Informer().GetIndexer()
+	c.secretLister = sif.Core().V1().Secrets().Lister()
+
 	sif.Start(stopCh)
 	for inf, sync := range sif.WaitForCacheSync(stopCh) {
 		if !sync {
@@ -74,30 +126,53 @@ func NewController(config *ControllerConfig) *Controller {
 		}
 	}
 
-	c.indexer = sif.Networking().V1().Ingresses().Informer().GetIndexer()
-	c.lister = sif.Networking().V1().Ingresses().Lister()
+	if c.envoyXDS != nil {
+		// Watch for events related to Clusters, as their topology is used to place the leaves in Envoy localities.
+		dsif := dynamicinformer.NewDynamicSharedInformerFactory(dynamic.NewForConfigOrDie(config.Cfg), resyncPeriod)
+		clusterInformer := dsif.ForResource(clusterResource).Informer()
+		clusterInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { c.ingressesFromCluster(obj) },
+			UpdateFunc: func(_, obj interface{}) { c.ingressesFromCluster(obj) },
+			DeleteFunc: func(obj interface{}) { c.ingressesFromCluster(obj) },
+		})
+		c.clusterIndexer = clusterInformer.GetIndexer()
+
+		dsif.Start(stopCh)
+		for gvr, sync := range dsif.WaitForCacheSync(stopCh) {
+			if !sync {
+				klog.Fatalf("Failed to sync %s", gvr)
+			}
+		}
+	}
 
 	return c
 }
 
 type ControllerConfig struct {
 	Cfg             *rest.Config
-	EnvoyXDS        *envoyserver.XdsServer
+	EnvoyXDS        *envoy.XdsServer
 	Domain          *string
-	EnvoyListenPort *uint
+	EnvoyTranslator *envoy.TranslatorConfig
+	// EnvoyFleets are the Envoy fleets, each one getting its own snapshot.
+	EnvoyFleets []envoy.Fleet
 }
 
 type Controller struct {
-	queue           workqueue.RateLimitingInterface
-	client          kubernetes.Interface
-	stopCh          chan struct{}
-	indexer         cache.Indexer
-	lister          networkingv1lister.IngressLister
-	envoyXDS        *envoyserver.XdsServer
-	envoyListenPort *uint
-	cache           *envoy.Cache
-	domain          *string
-	tracker         Tracker
+	queue            workqueue.RateLimitingInterface
+	client           kubernetes.Interface
+	stopCh           chan struct{}
+	indexer          cache.Indexer
+	lister           networkingv1lister.IngressLister
+	serviceIndexer   cache.Indexer
+	endpointsIndexer cache.Indexer
+	secretIndexer    cache.Indexer
+	secretLister     corev1lister.SecretLister
+	clusterIndexer   cache.Indexer
+	envoyXDS         *envoy.XdsServer
+	envoyListenPort  *uint
+	cache            *envoy.Cache
+	domain           *string
+	recorder         record.EventRecorder
 }
 
 func (c *Controller) enqueue(obj interface{}) {
@@ -168,23 +243,33 @@ func (c *Controller) process(key string) error {
 		return err
 	}
 
+	ctx := context.TODO()
+
 	if !exists {
 		klog.Infof("Object with key %q was deleted", key)
+		// A deleted leaf may have been the last one referencing a copy of a TLS Secret.
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			return err
+		}
+		clusterName, _ := clusters.SplitClusterAwareKey(name)
+		if err := c.deleteUnusedSecretCopies(ctx, namespace, clusterName, "", nil); err != nil {
+			return err
+		}
 		// If Envoy is enabled, delete the Ingress from the config cache.
 		if c.envoyXDS != nil {
 			// if EnvoyXDS is enabled, delete the Ingress from the cache and set the new snaphost.
 			c.cache.DeleteIngress(key)
-			c.envoyXDS.SetSnapshot(envoy.NodeID, c.cache.ToEnvoySnapshot())
+			if err := c.setSnapshot(ctx); err != nil {
+				return err
+			}
 		}
-		// The ingress has been deleted, so we remove any ingress to service tracking.
-		c.tracker.deleteIngress(key)
 		return nil
 	}
 	current := obj.(*networkingv1.Ingress)
 
 	previous := current.DeepCopy()
 
-	ctx := context.TODO()
 	if err := c.reconcile(ctx, current); err != nil {
 		return err
 	}
@@ -198,17 +283,132 @@ func (c *Controller) process(key string) error {
 	return err
 }
 
+// setSnapshot sends the new snapshots of the Envoy cache to the Envoy control plane, one per fleet, and reports the
+// problems found when serving the Ingresses as events on them, and in the WarningsAnnotation of the ones whose
+// problems changed, the Ingresses losing a host conflict included.
+func (c *Controller) setSnapshot(ctx context.Context) error {
+	// Most leaf status updates don't change the Envoy configuration, so only the fleets whose snapshot changed get it.
+	warnings, changed, err := c.cache.PushSnapshots(c.envoyXDS.SetSnapshot)
+	for i := range warnings {
+		klog.Infof("Ingress %q %s: %s", warnings[i].Ingress.Name, warnings[i].Reason, warnings[i].Message)
+		c.recorder.Event(&warnings[i].Ingress, v1.EventTypeWarning, warnings[i].Reason, warnings[i].Message)
+	}
+	return utilerrors.NewAggregate([]error{err, c.setWarningsAnnotations(ctx, changed)})
+}
+
 // ingressesFromService enqueues all the related ingresses for a given service.
 func (c *Controller) ingressesFromService(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	service, ok := obj.(*v1.Service)
+	if !ok {
+		return
+	}
+
 	// Does that Service has any Ingress associated to?
-	ingresses, ok := c.tracker.getIngress(obj.(*v1.Service))
-	if ok {
-		// One Service can be referenced by 0..n Ingresses, so we need to enqueue all the related ingreses.
-		for _, ingress := range ingresses {
-			klog.Infof("tracked service %q triggered Ingress %q reconciliation", obj.(*v1.Service).Name, ingress.Name)
-			c.enqueue(ingress.DeepCopy())
+	ingresses, err := c.indexer.ByIndex(serviceIndex, serviceToKey(service))
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	if len(ingresses) == 0 {
+		klog.Info("Ignoring non-tracked service: ", service.Name)
+		return
+	}
+
+	// One Service can be referenced by 0..n Ingresses, so we need to enqueue all the related ingreses.
+	for _, ingress := range ingresses {
+		klog.Infof("tracked service %q triggered Ingress %q reconciliation", service.Name, ingress.(*networkingv1.Ingress).Name)
+		c.enqueueWithLeaves(ingress.(*networkingv1.Ingress))
+	}
+}
+
+// ingressesFromSecret enqueues all the root ingresses referencing a given TLS Secret.
+func (c *Controller) ingressesFromSecret(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	secret, ok := obj.(*v1.Secret)
+	if !ok || secret.Labels[copyOfLabel] != "" {
+		return
+	}
+
+	ingresses, err := c.indexer.ByIndex(secretIndex, secretKey(secret.ClusterName, secret.Namespace, secret.Name))
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, ingress := range ingresses {
+		klog.Infof("tracked secret %q triggered Ingress %q reconciliation", secret.Name, ingress.(*networkingv1.Ingress).Name)
+		c.enqueueWithLeaves(ingress.(*networkingv1.Ingress))
+	}
+}
+
+// enqueueWithLeaves enqueues a root Ingress, and its leaves when Envoy is enabled, as the Envoy cache is updated when
+// reconciling the leaves.
+func (c *Controller) enqueueWithLeaves(root *networkingv1.Ingress) {
+	c.enqueue(root)
+	if c.envoyXDS == nil {
+		return
+	}
+	leaves, err := c.leaves(root)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, leaf := range leaves {
+		c.enqueue(leaf)
+	}
+}
+
+// ingressesFromEndpoints enqueues the leaves of all the root ingresses referencing the Service of the given Endpoints.
+// The status of the root Ingress is aggregated when reconciling its leaves.
+func (c *Controller) ingressesFromEndpoints(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	endpoints, ok := obj.(*v1.Endpoints)
+	if !ok {
+		return
+	}
+
+	// Endpoints share the namespace and name of their Service.
+	roots, err := c.indexer.ByIndex(serviceIndex, serviceKey(endpoints.ClusterName, endpoints.Namespace, endpoints.Name))
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+
+	for _, root := range roots {
+		leaves, err := c.leaves(root.(*networkingv1.Ingress))
+		if err != nil {
+			runtime.HandleError(err)
+			return
+		}
+		for _, leaf := range leaves {
+			klog.Infof("endpoints %q triggered Ingress %q reconciliation", endpoints.Name, leaf.Name)
+			c.enqueue(leaf)
+		}
+	}
+}
+
+// leaves returns the leaves owned by the given root Ingress.
+func (c *Controller) leaves(root *networkingv1.Ingress) ([]*networkingv1.Ingress, error) {
+	sel, err := labels.Parse(fmt.Sprintf("%s=%s", ownedByLabel, root.Name))
+	if err != nil {
+		return nil, err
+	}
+	all, err := c.lister.Ingresses(root.Namespace).List(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	var leaves []*networkingv1.Ingress
+	for _, leaf := range all {
+		if leaf.ClusterName == root.ClusterName {
+			leaves = append(leaves, leaf)
 		}
-	} else {
-		klog.Info("Ignoring non-tracked service: ", obj.(*v1.Service).Name)
 	}
+	return leaves, nil
 }
@@ -0,0 +1,55 @@
+package ingress
+
+import (
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// unhealthyClusters returns the clusters where at least one of the backends of the root Ingress
+// has no ready endpoints. Leaves on those clusters get no traffic and are left out of the root status.
+//
+// Services whose Endpoints have not been synced yet are not considered, as we can't tell if they are ready or not.
+func (c *Controller) unhealthyClusters(root *networkingv1.Ingress) (map[string]struct{}, error) {
+	unhealthy := map[string]struct{}{}
+	for _, name := range backendServiceNames(root) {
+		meta := metav1.ObjectMeta{
+			Namespace:   root.Namespace,
+			Name:        name,
+			ClusterName: root.ClusterName,
+		}
+
+		svcIf, exists, err := c.serviceIndexer.Get(&v1.Service{ObjectMeta: meta})
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		cluster := svcIf.(*v1.Service).Labels[clusterLabel]
+		if cluster == "" {
+			continue
+		}
+
+		epIf, exists, err := c.endpointsIndexer.Get(&v1.Endpoints{ObjectMeta: meta})
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		if !hasReadyEndpoints(epIf.(*v1.Endpoints)) {
+			unhealthy[cluster] = struct{}{}
+		}
+	}
+	return unhealthy, nil
+}
+
+func hasReadyEndpoints(endpoints *v1.Endpoints) bool {
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
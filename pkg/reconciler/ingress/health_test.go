@@ -0,0 +1,59 @@
+package ingress
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUnhealthyClusters(t *testing.T) {
+	service := func(name, cluster string) *v1.Service {
+		s := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		if cluster != "" {
+			s.Labels = map[string]string{clusterLabel: cluster}
+		}
+		return s
+	}
+	endpoints := func(name string, subsets ...v1.EndpointSubset) *v1.Endpoints {
+		return &v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}, Subsets: subsets}
+	}
+	ready := v1.EndpointSubset{Addresses: []v1.EndpointAddress{{IP: "10.0.0.1"}}}
+	notReady := v1.EndpointSubset{NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.0.2"}}}
+
+	c := newTestController(t,
+		// Endpoints not synced yet, so the cluster can't be told unhealthy.
+		service("unsynced", "cluster-a"),
+		service("empty", "cluster-b"), endpoints("empty"),
+		service("not-ready", "cluster-c"), endpoints("not-ready", notReady),
+		service("ready", "cluster-d"), endpoints("ready", notReady, ready),
+		// Services of the root cluster aren't on any of the clusters of the leaves.
+		service("unlabeled", ""), endpoints("unlabeled", notReady),
+	)
+
+	unhealthy, err := c.unhealthyClusters(newTestRoot("root", "unsynced", "empty", "not-ready", "ready", "unlabeled", "missing"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := map[string]struct{}{"cluster-b": {}, "cluster-c": {}}; !reflect.DeepEqual(unhealthy, expected) {
+		t.Errorf("expected the unhealthy clusters %v, got %v", expected, unhealthy)
+	}
+}
+
+func TestHasReadyEndpoints(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		subsets []v1.EndpointSubset
+		ready   bool
+	}{
+		{name: "no subsets", subsets: nil},
+		{name: "empty subset", subsets: []v1.EndpointSubset{{}}},
+		{name: "not ready addresses only", subsets: []v1.EndpointSubset{{NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.0.1"}}}}},
+		{name: "ready address in a later subset", subsets: []v1.EndpointSubset{{}, {Addresses: []v1.EndpointAddress{{IP: "10.0.0.1"}}}}, ready: true},
+	} {
+		if ready := hasReadyEndpoints(&v1.Endpoints{Subsets: test.subsets}); ready != test.ready {
+			t.Errorf("%s: expected ready %t, got %t", test.name, test.ready, ready)
+		}
+	}
+}
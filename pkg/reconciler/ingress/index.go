@@ -0,0 +1,57 @@
+package ingress
+
+import (
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// serviceIndex indexes root Ingresses by the backend Services they reference.
+const serviceIndex = "service"
+
+// indexByService returns the keys of all the Services referenced by a root Ingress.
+// Leaves reference the same Services, but only the root needs to be reconciled when one changes.
+func indexByService(obj interface{}) ([]string, error) {
+	ingress, ok := obj.(*networkingv1.Ingress)
+	if !ok || ingress.Labels[clusterLabel] != "" {
+		return nil, nil
+	}
+
+	var keys []string
+	seen := map[string]struct{}{}
+	for _, name := range backendServiceNames(ingress) {
+		key := serviceKey(ingress.ClusterName, ingress.Namespace, name)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// backendServiceNames returns the names of the Services referenced by the Ingress backends, in order.
+func backendServiceNames(ingress *networkingv1.Ingress) []string {
+	var names []string
+	if b := ingress.Spec.DefaultBackend; b != nil && b.Service != nil {
+		names = append(names, b.Service.Name)
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil {
+				names = append(names, path.Backend.Service.Name)
+			}
+		}
+	}
+	return names
+}
+
+func serviceToKey(service *v1.Service) string {
+	return serviceKey(service.ClusterName, service.Namespace, service.Name)
+}
+
+func serviceKey(clusterName, namespace, name string) string {
+	return namespace + "/" + clusterName + "#$#" + name
+}
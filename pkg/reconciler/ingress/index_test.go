@@ -0,0 +1,50 @@
+package ingress
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestIndexByService(t *testing.T) {
+	root := newTestRoot("root", "foo", "bar", "foo")
+	root.ClusterName = "admin"
+	root.Spec.DefaultBackend = &networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "default"}}
+	// Resource backends don't reference any Service.
+	root.Spec.Rules[0].HTTP.Paths = append(root.Spec.Rules[0].HTTP.Paths, networkingv1.HTTPIngressPath{
+		Path:    "/static",
+		Backend: networkingv1.IngressBackend{Resource: &v1.TypedLocalObjectReference{APIGroup: pointer.StringPtr("storage.k8s.io"), Kind: "Bucket", Name: "static"}},
+	})
+	root.Spec.Rules = append(root.Spec.Rules, networkingv1.IngressRule{Host: "bar.com"})
+
+	if names := backendServiceNames(root); !reflect.DeepEqual(names, []string{"default", "foo", "bar", "foo"}) {
+		t.Errorf("expected the default backend and then the paths in order, got %v", names)
+	}
+
+	keys, err := indexByService(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{serviceKey("admin", "default", "default"), serviceKey("admin", "default", "foo"), serviceKey("admin", "default", "bar")}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected the keys %v, got %v", expected, keys)
+	}
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default", ClusterName: "admin"}}
+	if key := serviceToKey(service); key != keys[1] {
+		t.Errorf("expected the Service key %q to match the index, got %q", keys[1], key)
+	}
+
+	// Only the roots are reconciled when their Services change.
+	leaf := root.DeepCopy()
+	leaf.Labels = map[string]string{clusterLabel: "cluster-a", ownedByLabel: "root"}
+	if keys, err := indexByService(leaf); keys != nil || err != nil {
+		t.Errorf("expected leaves not to be indexed, got %v, %v", keys, err)
+	}
+	if keys, err := indexByService(&v1.Service{}); keys != nil || err != nil {
+		t.Errorf("expected other objects not to be indexed, got %v, %v", keys, err)
+	}
+}
@@ -2,6 +2,7 @@ package ingress
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"hash/fnv"
 	"strings"
@@ -11,9 +12,12 @@ import (
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 )
@@ -78,6 +82,14 @@ func (c *Controller) reconcile(ctx context.Context, ingress *networkingv1.Ingres
 			}
 		}
 
+		// Place a copy of the TLS Secrets alongside each leaf, and clean up the ones no leaf is using anymore.
+		if err := c.syncSecretCopies(ctx, ingress, desiredLeaves); err != nil {
+			return err
+		}
+		if err := c.deleteUnusedSecretCopies(ctx, ingress.Namespace, ingress.ClusterName, ingress.Name, desiredLeaves); err != nil {
+			return err
+		}
+
 	} else {
 		// If the ingress has the clusterLabel set, that means that it is a leaf and it's synced with
 		// a cluster.
@@ -85,15 +97,6 @@ func (c *Controller) reconcile(ctx context.Context, ingress *networkingv1.Ingres
 		// This update can come from the creation or because the syncer has update the status.
 
 		rootIngressName := ingress.Labels[ownedByLabel]
-		// A leaf Ingress was updated; get others and aggregate status.
-		sel, err := labels.Parse(fmt.Sprintf("%s=%s", ownedByLabel, rootIngressName))
-		if err != nil {
-			return err
-		}
-		others, err := c.lister.List(sel)
-		if err != nil {
-			return err
-		}
 
 		// Get the rootIngress based on the labels.
 		var rootIngress *networkingv1.Ingress
@@ -116,19 +119,51 @@ func (c *Controller) reconcile(ctx context.Context, ingress *networkingv1.Ingres
 
 		rootIngress = rootIf.(*networkingv1.Ingress).DeepCopy()
 
+		// A leaf Ingress was updated; get others and aggregate status.
+		others, err := c.leaves(rootIngress)
+		if err != nil {
+			return err
+		}
+
+		// Leaves on clusters without ready endpoints don't get any traffic.
+		unhealthy, err := c.unhealthyClusters(rootIngress)
+		if err != nil {
+			return err
+		}
+
 		// Clean the current status, and then recreate if from the other leafs.
 		rootIngress.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{}
+		var envoyLeaves []envoy.Leaf
 		for _, o := range others {
+			if _, ok := unhealthy[o.Labels[clusterLabel]]; ok {
+				klog.Infof("Ignoring leaf %q status, cluster %q has no ready endpoints", o.Name, o.Labels[clusterLabel])
+				continue
+			}
 			rootIngress.Status.LoadBalancer.Ingress = append(rootIngress.Status.LoadBalancer.Ingress, o.Status.LoadBalancer.Ingress...)
+
+			if c.envoyXDS != nil {
+				leaf, err := c.envoyLeaf(o)
+				if err != nil {
+					return err
+				}
+				envoyLeaves = append(envoyLeaves, leaf)
+			}
 		}
 
 		// If the envoy controlplane is enabled, we update the cache and generate and send to envoy a new snapshot.
 		if c.envoyXDS != nil {
-			c.cache.UpdateIngress(*rootIngress)
-			err = c.envoyXDS.SetSnapshot(envoy.NodeID, c.cache.ToEnvoySnapshot())
+			if err := c.updateCachedSecrets(rootIngress); err != nil {
+				return err
+			}
+			services, err := c.backendServices(rootIngress)
 			if err != nil {
 				return err
 			}
+			// The problems found are reported by setSnapshot, along with the host conflicts.
+			c.cache.UpdateIngress(*rootIngress, envoyLeaves, services)
+			if err := c.setSnapshot(ctx); err != nil {
+				return err
+			}
 
 			statusHost := generateStatusHost(c.domain, rootIngress)
 			// Now overwrite the Status of the rootIngress with our desired LB
@@ -153,6 +188,50 @@ func (c *Controller) reconcile(ctx context.Context, ingress *networkingv1.Ingres
 	return nil
 }
 
+// setWarningsAnnotations lists the current problems of the root Ingresses in their WarningsAnnotation, so they are kept
+// after their events expire. The annotation is patched, so it doesn't conflict with the updates of the rest of the
+// Ingresses, and doesn't get them reconciled again, see onlyWarningsChanged.
+func (c *Controller) setWarningsAnnotations(ctx context.Context, changed []envoy.IngressWarnings) error {
+	var errs []error
+	for _, ingressWarnings := range changed {
+		// A null value removes the annotation.
+		var value *string
+		if len(ingressWarnings.Warnings) > 0 {
+			formatted := envoy.FormatWarnings(ingressWarnings.Warnings)
+			value = &formatted
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]*string{envoy.WarningsAnnotation: value},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		ingress := ingressWarnings.Ingress
+		if _, err := c.client.NetworkingV1().Ingresses(ingress.Namespace).Patch(ctx, ingress.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to set the warnings of Ingress %q: %v", ingress.Name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// onlyWarningsChanged tells whether an update of an Ingress only changed its WarningsAnnotation. Resyncs don't change
+// anything, and are still reconciled.
+func onlyWarningsChanged(old, ingress *networkingv1.Ingress) bool {
+	if old.ResourceVersion == ingress.ResourceVersion || old.Annotations[envoy.WarningsAnnotation] == ingress.Annotations[envoy.WarningsAnnotation] {
+		return false
+	}
+	old, ingress = old.DeepCopy(), ingress.DeepCopy()
+	for _, i := range []*networkingv1.Ingress{old, ingress} {
+		delete(i.Annotations, envoy.WarningsAnnotation)
+		i.ResourceVersion = ""
+		i.ManagedFields = nil
+	}
+	// Nil and empty annotations are equal.
+	return equality.Semantic.DeepEqual(old, ingress)
+}
+
 func (c *Controller) desiredLeaves(ctx context.Context, root *networkingv1.Ingress) ([]*networkingv1.Ingress, error) {
 	// This will parse the ingresses and extract all the destination services,
 	// then create a new ingress leaf for each of them.
@@ -168,9 +247,6 @@ func (c *Controller) desiredLeaves(ctx context.Context, root *networkingv1.Ingre
 		} else {
 			klog.Infof("Skipping service %q because it is not assigned to any cluster", service.Name)
 		}
-
-		// Trigger reconciliation of the root ingress when this service changes.
-		c.tracker.add(root, service)
 	}
 
 	if len(clusterDests) == 0 {
@@ -182,16 +258,28 @@ func (c *Controller) desiredLeaves(ctx context.Context, root *networkingv1.Ingre
 		return nil, nil
 	}
 
+	// There's a leaf for every cluster with a backend, even the ones with no weight in the traffic split of the root
+	// Ingress, so they can be ramped up.
 	desiredLeaves := make([]*networkingv1.Ingress, 0, len(clusterDests))
 	for _, cl := range clusterDests {
 		vd := root.DeepCopy()
 		// TODO: munge cluster name
 		vd.Name = fmt.Sprintf("%s--%s", root.Name, cl)
 
+		// The warnings are about the root Ingress.
+		delete(vd.Annotations, envoy.WarningsAnnotation)
+
 		vd.Labels = map[string]string{}
 		vd.Labels[clusterLabel] = cl
 		vd.Labels[ownedByLabel] = root.Name
 
+		// Point the leaf to the copies of the TLS Secrets placed in its cluster.
+		for i := range vd.Spec.TLS {
+			if vd.Spec.TLS[i].SecretName != "" {
+				vd.Spec.TLS[i].SecretName = secretCopyName(vd.Spec.TLS[i].SecretName, cl)
+			}
+		}
+
 		// Cleanup all the other owner references.
 		// TODO(jmprusi): Right now the syncer is syncing the OwnerReferences causing the ingresses to be deleted.
 		vd.OwnerReferences = []metav1.OwnerReference{}
@@ -221,7 +309,7 @@ func generateStatusHost(domain *string, ingress *networkingv1.Ingress) string {
 	}
 
 	//TODO(jmprusi): Hardcoded to the first one...
-	if allRulesAreDomain {
+	if allRulesAreDomain && len(ingress.Spec.Rules) > 0 {
 		return ingress.Spec.Rules[0].Host
 	}
 
@@ -231,14 +319,31 @@ func generateStatusHost(domain *string, ingress *networkingv1.Ingress) string {
 // getServices will parse the ingress object and return a list of the services.
 func (c *Controller) getServices(ctx context.Context, ingress *networkingv1.Ingress) ([]*v1.Service, error) {
 	var services []*v1.Service
-	for _, rule := range ingress.Spec.Rules {
-		for _, path := range rule.HTTP.Paths {
-			svc, err := c.client.CoreV1().Services(ingress.Namespace).Get(ctx, path.Backend.Service.Name, metav1.GetOptions{})
-			// TODO(jmprusi): If one of the services doesn't exist, we invalidate all the other ones.. review this.
-			if err != nil {
-				return nil, err
-			}
-			services = append(services, svc)
+	for _, name := range backendServiceNames(ingress) {
+		svc, err := c.client.CoreV1().Services(ingress.Namespace).Get(ctx, name, metav1.GetOptions{})
+		// TODO(jmprusi): If one of the services doesn't exist, we invalidate all the other ones.. review this.
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// backendServices returns the backend Services of the root Ingress found in the informer cache.
+func (c *Controller) backendServices(root *networkingv1.Ingress) ([]v1.Service, error) {
+	var services []v1.Service
+	for _, name := range backendServiceNames(root) {
+		svcIf, exists, err := c.serviceIndexer.Get(&v1.Service{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   root.Namespace,
+			Name:        name,
+			ClusterName: root.ClusterName,
+		}})
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			services = append(services, *svcIf.(*v1.Service))
 		}
 	}
 	return services, nil
@@ -0,0 +1,115 @@
+package ingress
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmprusi/kcp-ingress/pkg/envoy"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestRoot returns a root Ingress with a path per backend Service.
+func newTestRoot(name string, services ...string) *networkingv1.Ingress {
+	root := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	paths := make([]networkingv1.HTTPIngressPath, 0, len(services))
+	for _, service := range services {
+		paths = append(paths, networkingv1.HTTPIngressPath{
+			Path:    "/" + service,
+			Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: service}},
+		})
+	}
+	root.Spec.Rules = []networkingv1.IngressRule{{
+		Host:             "foo.com",
+		IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths}},
+	}}
+	return root
+}
+
+// newTestController returns a controller whose client and indexers are backed by a fake clientset holding the objects.
+func newTestController(t *testing.T, objects ...runtime.Object) *Controller {
+	client := fake.NewSimpleClientset(objects...)
+	sif := informers.NewSharedInformerFactory(client, 0)
+	c := &Controller{
+		client:           client,
+		indexer:          sif.Networking().V1().Ingresses().Informer().GetIndexer(),
+		lister:           sif.Networking().V1().Ingresses().Lister(),
+		serviceIndexer:   sif.Core().V1().Services().Informer().GetIndexer(),
+		endpointsIndexer: sif.Core().V1().Endpoints().Informer().GetIndexer(),
+		secretIndexer:    sif.Core().V1().Secrets().Informer().GetIndexer(),
+		secretLister:     sif.Core().V1().Secrets().Lister(),
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	sif.Start(stopCh)
+	for inf, synced := range sif.WaitForCacheSync(stopCh) {
+		if !synced {
+			t.Fatalf("failed to sync %s", inf)
+		}
+	}
+	return c
+}
+
+func TestSetWarningsAnnotations(t *testing.T) {
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{
+		Name:        "foo",
+		Namespace:   "default",
+		Annotations: map[string]string{"foo": "bar"},
+	}}
+	c := &Controller{client: fake.NewSimpleClientset(ingress)}
+	ctx := context.TODO()
+	warnings := []envoy.Warning{{Ingress: *ingress, Reason: envoy.ReasonHostConflict, Message: `host "foo.com" is claimed`}}
+
+	if err := c.setWarningsAnnotations(ctx, []envoy.IngressWarnings{{Ingress: *ingress, Warnings: warnings}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	patched, err := c.client.NetworkingV1().Ingresses("default").Get(ctx, "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value := patched.Annotations[envoy.WarningsAnnotation]; value != envoy.FormatWarnings(warnings) {
+		t.Errorf("unexpected warnings annotation %q", value)
+	}
+	if patched.Annotations["foo"] != "bar" {
+		t.Errorf("expected the other annotations to be kept, got %v", patched.Annotations)
+	}
+
+	if err := c.setWarningsAnnotations(ctx, []envoy.IngressWarnings{{Ingress: *ingress}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	patched, _ = c.client.NetworkingV1().Ingresses("default").Get(ctx, "foo", metav1.GetOptions{})
+	if _, ok := patched.Annotations[envoy.WarningsAnnotation]; ok {
+		t.Errorf("expected the warnings annotation to be removed, got %v", patched.Annotations)
+	}
+
+	// Deleted Ingresses are skipped.
+	deleted := networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "deleted", Namespace: "default"}}
+	if err := c.setWarningsAnnotations(ctx, []envoy.IngressWarnings{{Ingress: deleted, Warnings: warnings}}); err != nil {
+		t.Errorf("unexpected error for a deleted Ingress: %v", err)
+	}
+}
+
+func TestOnlyWarningsChanged(t *testing.T) {
+	old := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default", ResourceVersion: "1"}}
+
+	annotated := old.DeepCopy()
+	annotated.ResourceVersion = "2"
+	annotated.Annotations = map[string]string{envoy.WarningsAnnotation: "HostConflict: foo"}
+	if !onlyWarningsChanged(old, annotated) {
+		t.Errorf("expected an update of the warnings annotation only to be skipped")
+	}
+
+	updated := annotated.DeepCopy()
+	updated.Annotations[envoy.LoadBalancingAnnotation] = envoy.LoadBalancingFailover
+	if onlyWarningsChanged(old, updated) {
+		t.Errorf("expected an update of another annotation to be reconciled")
+	}
+
+	// Resyncs don't change anything.
+	if onlyWarningsChanged(annotated, annotated) {
+		t.Errorf("expected a resync to be reconciled")
+	}
+}
@@ -0,0 +1,196 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	// copyOfLabel is set on the per cluster copies of the TLS Secrets, pointing to the original Secret.
+	copyOfLabel = "kcp.dev/copy-of"
+	// secretIndex indexes root Ingresses by the TLS Secrets they reference.
+	secretIndex = "secret"
+)
+
+// indexBySecret returns the keys of all the TLS Secrets referenced by a root Ingress.
+func indexBySecret(obj interface{}) ([]string, error) {
+	ingress, ok := obj.(*networkingv1.Ingress)
+	if !ok || ingress.Labels[clusterLabel] != "" {
+		return nil, nil
+	}
+
+	var keys []string
+	for _, name := range tlsSecretNames(ingress) {
+		keys = append(keys, secretKey(ingress.ClusterName, ingress.Namespace, name))
+	}
+	return keys, nil
+}
+
+// tlsSecretNames returns the names of the Secrets referenced by the Ingress TLS section, without duplicates.
+func tlsSecretNames(ingress *networkingv1.Ingress) []string {
+	var names []string
+	seen := map[string]struct{}{}
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName == "" {
+			continue
+		}
+		if _, ok := seen[tls.SecretName]; ok {
+			continue
+		}
+		seen[tls.SecretName] = struct{}{}
+		names = append(names, tls.SecretName)
+	}
+	return names
+}
+
+func secretKey(clusterName, namespace, name string) string {
+	return namespace + "/" + clusterName + "#$#" + name
+}
+
+// secretCopyName returns the name of the copy of the Secret placed in the given cluster.
+// Leaves reference the copy instead of the original Secret, as a kcp object can only be synced to a single cluster.
+func secretCopyName(secret, cluster string) string {
+	return fmt.Sprintf("%s--%s", secret, cluster)
+}
+
+// syncSecretCopies creates or updates a copy of every TLS Secret referenced by the root Ingress, for each of the clusters
+// of its leaves.
+func (c *Controller) syncSecretCopies(ctx context.Context, root *networkingv1.Ingress, leaves []*networkingv1.Ingress) error {
+	for _, name := range tlsSecretNames(root) {
+		secretIf, exists, err := c.secretIndexer.Get(&v1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   root.Namespace,
+			Name:        name,
+			ClusterName: root.ClusterName,
+		}})
+		if err != nil {
+			return err
+		}
+		if !exists {
+			// The root Ingress will be reconciled again once the Secret is created.
+			klog.Infof("TLS Secret %q referenced by Ingress %q not found", name, root.Name)
+			continue
+		}
+		secret := secretIf.(*v1.Secret)
+
+		for _, leaf := range leaves {
+			cl := leaf.Labels[clusterLabel]
+			desired := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   secret.Namespace,
+					Name:        secretCopyName(secret.Name, cl),
+					ClusterName: secret.ClusterName,
+					Labels: map[string]string{
+						clusterLabel: cl,
+						copyOfLabel:  secret.Name,
+					},
+				},
+				Type: secret.Type,
+				Data: secret.Data,
+			}
+
+			existingIf, exists, err := c.secretIndexer.Get(desired)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				klog.Infof("Copying TLS Secret %q to cluster %q", secret.Name, cl)
+				if _, err := c.client.CoreV1().Secrets(desired.Namespace).Create(ctx, desired, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+					return err
+				}
+				continue
+			}
+
+			existing := existingIf.(*v1.Secret)
+			if existing.Type == desired.Type && equality.Semantic.DeepEqual(existing.Data, desired.Data) &&
+				equality.Semantic.DeepEqual(existing.Labels, desired.Labels) {
+				continue
+			}
+			klog.Infof("Updating copy of TLS Secret %q in cluster %q", secret.Name, cl)
+			desired.ResourceVersion = existing.ResourceVersion
+			desired.UID = existing.UID
+			if _, err := c.client.CoreV1().Secrets(desired.Namespace).Update(ctx, desired, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteUnusedSecretCopies deletes the copies of TLS Secrets in the namespace that are not referenced by any leaf anymore.
+// The leaves owned by root, if set, are taken from desired instead of the informer cache, which may not be up to date yet.
+func (c *Controller) deleteUnusedSecretCopies(ctx context.Context, namespace, clusterName, root string, desired []*networkingv1.Ingress) error {
+	sel, err := labels.Parse(clusterLabel)
+	if err != nil {
+		return err
+	}
+	leaves, err := c.lister.Ingresses(namespace).List(sel)
+	if err != nil {
+		return err
+	}
+
+	referenced := map[string]struct{}{}
+	reference := func(leaf *networkingv1.Ingress) {
+		for _, tls := range leaf.Spec.TLS {
+			referenced[tls.SecretName] = struct{}{}
+		}
+	}
+	for _, leaf := range leaves {
+		if leaf.ClusterName != clusterName || (root != "" && leaf.Labels[ownedByLabel] == root) {
+			continue
+		}
+		reference(leaf)
+	}
+	for _, leaf := range desired {
+		reference(leaf)
+	}
+
+	sel, err = labels.Parse(copyOfLabel)
+	if err != nil {
+		return err
+	}
+	copies, err := c.secretLister.Secrets(namespace).List(sel)
+	if err != nil {
+		return err
+	}
+	for _, secretCopy := range copies {
+		if secretCopy.ClusterName != clusterName {
+			continue
+		}
+		if _, ok := referenced[secretCopy.Name]; ok {
+			continue
+		}
+		klog.Infof("Deleting unused copy %q of TLS Secret %q", secretCopy.Name, secretCopy.Labels[copyOfLabel])
+		if err := c.client.CoreV1().Secrets(namespace).Delete(ctx, secretCopy.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateCachedSecrets updates the Envoy cache with the TLS Secrets referenced by the root Ingress.
+func (c *Controller) updateCachedSecrets(root *networkingv1.Ingress) error {
+	for _, name := range tlsSecretNames(root) {
+		secretIf, exists, err := c.secretIndexer.Get(&v1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   root.Namespace,
+			Name:        name,
+			ClusterName: root.ClusterName,
+		}})
+		if err != nil {
+			return err
+		}
+		if !exists {
+			c.cache.DeleteSecret(root.Namespace, root.ClusterName, name)
+			continue
+		}
+		c.cache.UpdateSecret(*secretIf.(*v1.Secret))
+	}
+	return nil
+}
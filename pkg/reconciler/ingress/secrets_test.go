@@ -0,0 +1,155 @@
+package ingress
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestSecret(name, cert string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       v1.SecretTypeTLS,
+		Data:       map[string][]byte{v1.TLSCertKey: []byte(cert), v1.TLSPrivateKeyKey: []byte("key")},
+	}
+}
+
+func newTestSecretCopy(secret *v1.Secret, cluster string) *v1.Secret {
+	secretCopy := secret.DeepCopy()
+	secretCopy.Name = secretCopyName(secret.Name, cluster)
+	secretCopy.Labels = map[string]string{clusterLabel: cluster, copyOfLabel: secret.Name}
+	return secretCopy
+}
+
+// newTestLeaf returns a leaf of the root Ingress in the cluster, referencing the copies of the TLS Secrets.
+func newTestLeaf(root, cluster string, secrets ...string) *networkingv1.Ingress {
+	leaf := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{
+		Name:      root + "--" + cluster,
+		Namespace: "default",
+		Labels:    map[string]string{clusterLabel: cluster, ownedByLabel: root},
+	}}
+	for _, secret := range secrets {
+		leaf.Spec.TLS = append(leaf.Spec.TLS, networkingv1.IngressTLS{SecretName: secretCopyName(secret, cluster)})
+	}
+	return leaf
+}
+
+func TestSyncSecretCopies(t *testing.T) {
+	ctx := context.TODO()
+	root := newTestRoot("root", "svc")
+	root.Spec.TLS = []networkingv1.IngressTLS{{SecretName: "foo-tls"}, {SecretName: "missing-tls"}}
+	leaves := []*networkingv1.Ingress{newTestLeaf("root", "cluster-a", "foo-tls"), newTestLeaf("root", "cluster-b", "foo-tls")}
+
+	// A copy per cluster, the missing Secret being skipped until it's created.
+	secret := newTestSecret("foo-tls", "cert")
+	c := newTestController(t, secret)
+	if err := c.syncSecretCopies(ctx, root, leaves); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, cluster := range []string{"cluster-a", "cluster-b"} {
+		secretCopy, err := c.client.CoreV1().Secrets("default").Get(ctx, secretCopyName("foo-tls", cluster), metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected a copy in %s: %v", cluster, err)
+		}
+		if string(secretCopy.Data[v1.TLSCertKey]) != "cert" || secretCopy.Type != v1.SecretTypeTLS ||
+			secretCopy.Labels[clusterLabel] != cluster || secretCopy.Labels[copyOfLabel] != "foo-tls" {
+			t.Errorf("unexpected copy in %s: %v", cluster, secretCopy)
+		}
+	}
+
+	// The copies of a rotated certificate are updated.
+	rotated := newTestSecret("foo-tls", "rotated")
+	c = newTestController(t, rotated, newTestSecretCopy(secret, "cluster-a"), newTestSecretCopy(rotated, "cluster-b"))
+	if err := c.syncSecretCopies(ctx, root, leaves); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, cluster := range []string{"cluster-a", "cluster-b"} {
+		secretCopy, err := c.client.CoreV1().Secrets("default").Get(ctx, secretCopyName("foo-tls", cluster), metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(secretCopy.Data[v1.TLSCertKey]) != "rotated" {
+			t.Errorf("expected the copy in %s to be rotated, got %q", cluster, secretCopy.Data[v1.TLSCertKey])
+		}
+	}
+}
+
+func TestDeleteUnusedSecretCopies(t *testing.T) {
+	ctx := context.TODO()
+	foo, bar := newTestSecret("foo-tls", "cert"), newTestSecret("bar-tls", "cert")
+	objects := func(leaves ...*networkingv1.Ingress) []runtime.Object {
+		objects := []runtime.Object{
+			foo, newTestSecretCopy(foo, "cluster-a"), newTestSecretCopy(foo, "cluster-b"),
+			bar, newTestSecretCopy(bar, "cluster-a"),
+		}
+		for _, leaf := range leaves {
+			objects = append(objects, leaf)
+		}
+		return objects
+	}
+	exists := func(c *Controller, name string) bool {
+		_, err := c.client.CoreV1().Secrets("default").Get(ctx, name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return err == nil
+	}
+
+	for _, test := range []struct {
+		name string
+		// leaves are the leaves in the informer cache.
+		leaves []*networkingv1.Ingress
+		// root and desired are the root Ingress being reconciled and its desired leaves, none when one was deleted.
+		root    string
+		desired []*networkingv1.Ingress
+		kept    []string
+	}{
+		{
+			name:   "leaves still referencing the copies",
+			leaves: []*networkingv1.Ingress{newTestLeaf("root", "cluster-a", "foo-tls"), newTestLeaf("other", "cluster-b", "foo-tls"), newTestLeaf("other", "cluster-a", "bar-tls")},
+			kept:   []string{"foo-tls--cluster-a", "foo-tls--cluster-b", "bar-tls--cluster-a"},
+		},
+		{
+			name:   "last leaf deleted",
+			leaves: []*networkingv1.Ingress{newTestLeaf("other", "cluster-a", "bar-tls")},
+			kept:   []string{"bar-tls--cluster-a"},
+		},
+		{
+			name:    "leaves of the root not desired anymore",
+			leaves:  []*networkingv1.Ingress{newTestLeaf("root", "cluster-a", "foo-tls"), newTestLeaf("root", "cluster-b", "foo-tls"), newTestLeaf("other", "cluster-a", "bar-tls")},
+			root:    "root",
+			desired: []*networkingv1.Ingress{newTestLeaf("root", "cluster-b", "foo-tls")},
+			kept:    []string{"foo-tls--cluster-b", "bar-tls--cluster-a"},
+		},
+		{
+			name:   "copy referenced by the leaf of another root",
+			leaves: []*networkingv1.Ingress{newTestLeaf("root", "cluster-a", "foo-tls"), newTestLeaf("other", "cluster-b", "foo-tls"), newTestLeaf("other", "cluster-a", "bar-tls")},
+			root:   "root",
+			kept:   []string{"foo-tls--cluster-b", "bar-tls--cluster-a"},
+		},
+	} {
+		c := newTestController(t, objects(test.leaves...)...)
+		if err := c.deleteUnusedSecretCopies(ctx, "default", "", test.root, test.desired); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+
+		kept := map[string]struct{}{}
+		for _, name := range test.kept {
+			kept[name] = struct{}{}
+		}
+		for _, name := range []string{"foo-tls--cluster-a", "foo-tls--cluster-b", "bar-tls--cluster-a"} {
+			if _, ok := kept[name]; exists(c, name) != ok {
+				t.Errorf("%s: expected copy %s kept %t", test.name, name, ok)
+			}
+		}
+		// The original Secrets are never deleted.
+		if !exists(c, "foo-tls") || !exists(c, "bar-tls") {
+			t.Errorf("%s: expected the original Secrets to be kept", test.name)
+		}
+	}
+}